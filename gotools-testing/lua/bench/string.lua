@@ -0,0 +1,25 @@
+-- concatenation, the string library and string keys
+local parts = {}
+for i = 1, 100000 do
+    parts[#parts + 1] = "item" .. i
+end
+local s = table.concat(parts, ",")
+
+local count = 0
+for w in string.gmatch(s, "item(%d+)") do
+    count = count + 1
+end
+
+local seen = {}
+for i = 1, #parts do
+    local k = parts[i]:upper():sub(1, 6)
+    seen[k] = (seen[k] or 0) + 1
+end
+
+local acc = ""
+for i = 1, 20000 do
+    acc = acc .. string.char(97 + i % 26)
+end
+
+assert(count == 100000 and seen.ITEM10 == 1112 and #acc == 20000)
+print("ok")
@@ -0,0 +1,27 @@
+-- table constructors, indexing and sorting
+local n = 200000
+local t = {}
+for i = 1, n do
+    t[i] = {id = i, score = (i * 7919) % 1000}
+end
+
+local sum = 0
+for _, v in ipairs(t) do
+    sum = sum + v.score
+end
+
+local byScore = {}
+for i = 1, n do
+    local s = t[i].score
+    byScore[s] = (byScore[s] or 0) + 1
+end
+
+local keys = {}
+for k in pairs(byScore) do
+    keys[#keys + 1] = k
+end
+table.sort(keys)
+
+assert(#t == n and #keys == 1000 and keys[1] == 0)
+assert(sum == 99900000, sum)
+print("ok")
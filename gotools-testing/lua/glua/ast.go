@@ -0,0 +1,211 @@
+package glua
+
+// Pos is a position in the source, both line and column start at 1.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Stat is a statement node.
+type Stat interface {
+	stat()
+}
+
+// Expr is an expression node.
+type Expr interface {
+	expr()
+}
+
+// Block is a list of statements.
+type Block struct {
+	Pos
+	Stats []Stat
+}
+
+// ---
+
+// ExprStat evaluates an expression and drops the result.
+type ExprStat struct {
+	Pos
+	Expr Expr
+}
+
+// AssignStat is `a = 1`.
+type AssignStat struct {
+	Pos
+	Lhs []Expr
+	Rhs []Expr
+}
+
+// IfStat is `if Conds[0] then Blocks[0] elseif Conds[1] then Blocks[1] else Else end`.
+type IfStat struct {
+	Pos
+	Conds  []Expr
+	Blocks []*Block
+	Else   *Block
+}
+
+// DoStat is `do Block end`.
+type DoStat struct {
+	Pos
+	Block *Block
+}
+
+// WhileStat is `while Cond do Block end`.
+type WhileStat struct {
+	Pos
+	Cond  Expr
+	Block *Block
+}
+
+// RepeatStat is `repeat Block until Cond`, Cond is inside the scope of Block.
+type RepeatStat struct {
+	Pos
+	Block *Block
+	Cond  Expr
+}
+
+// NumForStat is `for Name = Start, Limit, Step do Block end`, Step may be nil.
+type NumForStat struct {
+	Pos
+	Name  string
+	Start Expr
+	Limit Expr
+	Step  Expr
+	Block *Block
+}
+
+// GenForStat is `for Names in Exprs do Block end`.
+type GenForStat struct {
+	Pos
+	Names []string
+	Exprs []Expr
+	Block *Block
+}
+
+// LocalStat is `local Names = Exprs`.
+type LocalStat struct {
+	Pos
+	Names []string
+	Exprs []Expr
+}
+
+// LocalFuncStat is `local function Name() end`.
+type LocalFuncStat struct {
+	Pos
+	Name string
+	Func *FuncExpr
+}
+
+// ReturnStat is `return Exprs`.
+type ReturnStat struct {
+	Pos
+	Exprs []Expr
+}
+
+// BreakStat is `break`.
+type BreakStat struct {
+	Pos
+}
+
+func (*ExprStat) stat()      {}
+func (*AssignStat) stat()    {}
+func (*IfStat) stat()        {}
+func (*DoStat) stat()        {}
+func (*WhileStat) stat()     {}
+func (*RepeatStat) stat()    {}
+func (*NumForStat) stat()    {}
+func (*GenForStat) stat()    {}
+func (*LocalStat) stat()     {}
+func (*LocalFuncStat) stat() {}
+func (*ReturnStat) stat()    {}
+func (*BreakStat) stat()     {}
+
+// ---
+
+// ConstExpr is a literal: nil, true/false, number or string.
+type ConstExpr struct {
+	Pos
+	Value interface{}
+}
+
+// NameExpr is a variable.
+type NameExpr struct {
+	Pos
+	Name string
+}
+
+// ParenExpr is `(a)`.
+type ParenExpr struct {
+	Pos
+	Expr Expr
+}
+
+// VarargExpr is `...`.
+type VarargExpr struct {
+	Pos
+}
+
+// FuncExpr is `function(Params) Block end`, Name is only used in messages
+// and Chunk is the name of the chunk the function was defined in.
+type FuncExpr struct {
+	Pos
+	Name   string
+	Chunk  string
+	Params []string
+	Vararg bool
+	Block  *Block
+}
+
+// IndexExpr is `Obj[Key]`, `Obj.name` has a string constant as Key.
+type IndexExpr struct {
+	Pos
+	Obj Expr
+	Key Expr
+}
+
+// TableExpr is `{Fields}`.
+type TableExpr struct {
+	Pos
+	Fields []Field
+}
+
+// Field is an item of a table constructor, Key is nil for positional items.
+type Field struct {
+	Key   Expr
+	Value Expr
+}
+
+// CallExpr is `f(a, b)`, or `f:Method(a, b)` if Method is not empty.
+type CallExpr struct {
+	Pos
+	Func   Expr
+	Method string
+	Args   []Expr
+}
+
+// UnOpExpr is `op a`, Op is the token of the operator.
+type UnOpExpr struct {
+	Pos
+	Op   int
+	Expr Expr
+}
+
+// BinOpExpr is `a op b`, Op is the token of the operator.
+type BinOpExpr struct {
+	Pos
+	Op  int
+	Lhs Expr
+	Rhs Expr
+}
+
+func (*ConstExpr) expr()  {}
+func (*NameExpr) expr()   {}
+func (*ParenExpr) expr()  {}
+func (*VarargExpr) expr() {}
+func (*FuncExpr) expr()   {}
+func (*IndexExpr) expr()  {}
+func (*TableExpr) expr()  {}
+func (*CallExpr) expr()   {}
+func (*UnOpExpr) expr()   {}
+func (*BinOpExpr) expr()  {}
@@ -0,0 +1,448 @@
+package glua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// luaFunc is a function written in Go, scripts see it as a *luaFunc so
+// that every function has an identity.
+type luaFunc func(*state, ...interface{}) []interface{}
+
+func newFunc(fn luaFunc) *luaFunc {
+	return &fn
+}
+
+// luaClosure is a function written in Lua and the scope it was created in,
+// or, once compiled, its prototype and upvalues.
+type luaClosure struct {
+	fn  *FuncExpr
+	env *scope
+
+	p      *proto
+	upvals []*upval
+}
+
+// luaUserdata is a Go value handed to scripts, its metatable gives it
+// methods and operators.
+type luaUserdata struct {
+	value interface{}
+	meta  *luaTable
+}
+
+// openBase registers the basic functions as globals.
+func (L *state) openBase() {
+	L.globals["_VERSION"] = "Lua 5.3 (BETA) ddosakura"
+	funcs := map[string]luaFunc{
+		"print": func(L *state, args ...interface{}) []interface{} {
+			for i, a := range args {
+				if i == 0 {
+					fmt.Print(L.tostr(a))
+				} else {
+					fmt.Print("\t" + L.tostr(a))
+				}
+			}
+			fmt.Println()
+			return nil
+		},
+		"assert": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				panic("bad argument #1 to 'assert' (value expected)")
+			}
+			if !truthy(args[0]) {
+				if len(args) > 1 {
+					panic(&luaError{args[1]})
+				}
+				panic("assertion failed!")
+			}
+			return args
+		},
+		"error": func(L *state, args ...interface{}) []interface{} {
+			v := argAt(args, 0)
+			level := int64(1)
+			if argAt(args, 1) != nil {
+				level = checkInteger(args, 2, "error")
+			}
+			if s, ok := v.(string); ok && level > 0 {
+				v = L.where(int(level)) + s
+			}
+			panic(&luaError{v})
+		},
+		"pcall": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				die("bad argument #1 to 'pcall' (value expected)")
+			}
+			return L.pcall(args[0], nil, args[1:])
+		},
+		"xpcall": func(L *state, args ...interface{}) []interface{} {
+			if len(args) < 2 {
+				die("bad argument #2 to 'xpcall' (value expected)")
+			}
+			return L.pcall(args[0], args[1], args[2:])
+		},
+		"type": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				panic("bad argument #1 to 'type' (value expected)")
+			}
+			return []interface{}{valType(args[0])}
+		},
+		"select": func(L *state, args ...interface{}) []interface{} {
+			if len(args) > 0 && args[0] == "#" {
+				return []interface{}{int64(len(args) - 1)}
+			}
+			i := int(checkInteger(args, 1, "select"))
+			if i < 0 {
+				i = len(args) + i
+			} else if i > len(args) {
+				i = len(args)
+			}
+			if i < 1 {
+				die("bad argument #1 to 'select' (index out of range)")
+			}
+			return args[i:]
+		},
+		"tostring": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				panic("bad argument #1 to 'tostring' (value expected)")
+			}
+			return []interface{}{L.tostr(args[0])}
+		},
+		"tonumber": func(L *state, args ...interface{}) []interface{} {
+			if len(args) < 2 || args[1] == nil {
+				switch a := argAt(args, 0).(type) {
+				case int64, float64:
+					return []interface{}{a}
+				case string:
+					if n, ok := strToNumber(a); ok {
+						return []interface{}{n}
+					}
+				case nil:
+					if len(args) == 0 {
+						die("bad argument #1 to 'tonumber' (value expected)")
+					}
+				}
+				return []interface{}{nil}
+			}
+			base := checkInteger(args, 2, "tonumber")
+			s, ok := args[0].(string)
+			if !ok {
+				die("bad argument #1 to 'tonumber' (string expected, got %s)", argType(args, 0))
+			}
+			if base < 2 || base > 36 {
+				die("bad argument #2 to 'tonumber' (base out of range)")
+			}
+			if n, ok := strToInteger(s, base); ok {
+				return []interface{}{n}
+			}
+			return []interface{}{nil}
+		},
+		"getmetatable": func(L *state, args ...interface{}) []interface{} {
+			mt := L.getMeta(argAt(args, 0))
+			if mt == nil {
+				return []interface{}{nil}
+			}
+			if p := mt.get("__metatable"); p != nil {
+				return []interface{}{p}
+			}
+			return []interface{}{mt}
+		},
+		"setmetatable": func(L *state, args ...interface{}) []interface{} {
+			t := checkTable(args, 1, "setmetatable")
+			mt, ok := argAt(args, 1).(*luaTable)
+			if !ok && argAt(args, 1) != nil {
+				die("bad argument #2 to 'setmetatable' (nil or table expected)")
+			}
+			if t.meta != nil && t.meta.get("__metatable") != nil {
+				die("cannot change a protected metatable")
+			}
+			t.meta = mt
+			return []interface{}{t}
+		},
+		"rawget": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{checkTable(args, 1, "rawget").get(argAt(args, 1))}
+		},
+		"rawset": func(L *state, args ...interface{}) []interface{} {
+			t := checkTable(args, 1, "rawset")
+			t.set(argAt(args, 1), argAt(args, 2))
+			return []interface{}{t}
+		},
+		"rawequal": func(L *state, args ...interface{}) []interface{} {
+			if len(args) < 2 {
+				die("bad argument #%d to 'rawequal' (value expected)", len(args)+1)
+			}
+			return []interface{}{rawEqual(args[0], args[1])}
+		},
+		"rawlen": func(L *state, args ...interface{}) []interface{} {
+			switch a := argAt(args, 0).(type) {
+			case *luaTable:
+				return []interface{}{int64(a.length())}
+			case string:
+				return []interface{}{int64(len(a))}
+			}
+			panic("table or string expected")
+		},
+		"require": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.require(checkString(args, 1, "require"))}
+		},
+		"load": func(L *state, args ...interface{}) []interface{} {
+			var src, name string
+			switch chunk := argAt(args, 0).(type) {
+			case string:
+				src, name = chunk, chunkName(chunk)
+				if strings.HasPrefix(src, dumpSignature[:1]) {
+					name = "binary string"
+				}
+			case *luaFunc, *luaClosure:
+				// concatenate the pieces returned by chunk until it
+				// returns nothing
+				var b strings.Builder
+				for {
+					s := argAt(L.call(chunk), 0)
+					if s == nil || s == "" {
+						break
+					}
+					piece, ok := s.(string)
+					if !ok {
+						return []interface{}{nil, "reader function must return a string"}
+					}
+					b.WriteString(piece)
+				}
+				src, name = b.String(), "(load)"
+			default:
+				die("bad argument #1 to 'load' (string expected, got %s)", argType(args, 0))
+			}
+			if len(args) > 1 && args[1] != nil {
+				name = loadName(checkString(args, 2, "load"))
+			}
+			mode := "bt"
+			if len(args) > 2 && args[2] != nil {
+				mode = checkString(args, 3, "load")
+			}
+			if argAt(args, 3) != nil {
+				// globals live in the State, chunks have no _ENV
+				die("bad argument #4 to 'load' (environments are not supported)")
+			}
+			fn, err := L.loadChunk(strings.NewReader(src), name, mode)
+			if err != nil {
+				return []interface{}{nil, err.Error()}
+			}
+			return []interface{}{fn}
+		},
+		"pairs": func(L *state, args ...interface{}) []interface{} {
+			if h := L.metaOf(argAt(args, 0), "__pairs"); h != nil {
+				rs := L.call(h, args[0])
+				return []interface{}{argAt(rs, 0), argAt(rs, 1), argAt(rs, 2)}
+			}
+			t := checkTable(args, 1, "pairs")
+			return []interface{}{nextFunc, t, nil}
+		},
+		"ipairs": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				die("bad argument #1 to 'ipairs' (table expected, got no value)")
+			}
+			return []interface{}{ipairsAux, args[0], int64(0)}
+		},
+	}
+	for name, fn := range funcs {
+		L.globals[name] = newFunc(fn)
+	}
+	L.globals["next"] = nextFunc
+}
+
+// nextFunc is the builtin next, pairs returns it.
+var nextFunc = newFunc(func(L *state, args ...interface{}) []interface{} {
+	t := checkTable(args, 1, "next")
+	var k interface{}
+	if len(args) > 1 {
+		k = args[1]
+	}
+	k, v := t.next(k)
+	return []interface{}{k, v}
+})
+
+// ipairsAux is the iterator ipairs returns, it goes through __index like
+// the reference implementation.
+var ipairsAux = newFunc(func(L *state, args ...interface{}) []interface{} {
+	i := checkInteger(args, 2, "ipairs") + 1
+	v := L.opIndex(args[0], i)
+	if v == nil {
+		return []interface{}{nil}
+	}
+	return []interface{}{i, v}
+})
+
+// argAt returns args[i], or nil if there are not enough arguments.
+func argAt(args []interface{}, i int) interface{} {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// argType is the type name of args[i] for error messages.
+func argType(args []interface{}, i int) string {
+	if i >= len(args) {
+		return "no value"
+	}
+	return valType(args[i])
+}
+
+// checkTable returns the n-th argument of the builtin fname, which must be
+// a table.
+func checkTable(args []interface{}, n int, fname string) *luaTable {
+	t, ok := argAt(args, n-1).(*luaTable)
+	if !ok {
+		die("bad argument #%d to '%s' (table expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return t
+}
+
+// checkString returns the n-th argument of the builtin fname, which must
+// be a string or a number.
+func checkString(args []interface{}, n int, fname string) string {
+	s, ok := concatString(argAt(args, n-1))
+	if !ok {
+		die("bad argument #%d to '%s' (string expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return s
+}
+
+// optInteger is checkInteger for an optional argument.
+func optInteger(args []interface{}, n int, fname string, def int64) int64 {
+	if argAt(args, n-1) == nil {
+		return def
+	}
+	return checkInteger(args, n, fname)
+}
+
+// checkInteger returns the n-th argument of the builtin fname, which must
+// be a number with an integer value.
+func checkInteger(args []interface{}, n int, fname string) int64 {
+	a := coerce(argAt(args, n-1))
+	i, ok := toInteger(a)
+	if !ok {
+		if isNumber(a) {
+			die("bad argument #%d to '%s' (number has no integer representation)", n, fname)
+		}
+		die("bad argument #%d to '%s' (number expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return i
+}
+
+func valType(a interface{}) string {
+	switch a.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case *luaFunc, *luaClosure:
+		return "function"
+	case *luaTable:
+		return "table"
+	case *luaCoroutine:
+		return "thread"
+	default:
+		return "userdata"
+	}
+}
+
+// tostr converts a value to the string print shows.
+func (L *state) tostr(a interface{}) string {
+	if h := L.metaOf(a, "__tostring"); h != nil {
+		s, ok := first(L.call(h, a)).(string)
+		if !ok {
+			die("'__tostring' must return a string")
+		}
+		return s
+	}
+	switch a := a.(type) {
+	case nil:
+		return "nil"
+	case int64, float64:
+		return numToStr(a)
+	case *luaTable, *luaClosure, *luaFunc, *luaCoroutine, *luaUserdata:
+		return fmt.Sprintf("%s: %p", valType(a), a)
+	}
+	return fmt.Sprint(a)
+}
+
+// unquote resolves the escape sequences of a Lua string literal.
+func unquote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			panic("unfinished string")
+		}
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n', '\n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"', '\'':
+			b.WriteByte(c)
+		case 'x':
+			if i+2 >= len(s) {
+				panic("hexadecimal digit expected")
+			}
+			n, e := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if e != nil {
+				panic("hexadecimal digit expected")
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		case 'z':
+			// skip the following white space
+			for i+1 < len(s) && strings.IndexByte(" \f\n\r\t\v", s[i+1]) >= 0 {
+				i++
+			}
+		case 'u':
+			j := strings.IndexByte(s[i:], '}')
+			if i+1 >= len(s) || s[i+1] != '{' || j < 0 {
+				panic("missing '{' in \\u{xxxx}")
+			}
+			n, e := strconv.ParseUint(s[i+2:i+j], 16, 31)
+			if e != nil {
+				panic("UTF-8 value too large")
+			}
+			b.WriteRune(rune(n))
+			i += j
+		default:
+			if c < '0' || c > '9' {
+				panic("invalid escape sequence '\\" + string(c) + "'")
+			}
+			// up to three decimal digits
+			n := 0
+			for k := 0; k < 3 && i < len(s) && '0' <= s[i] && s[i] <= '9'; k++ {
+				n = n*10 + int(s[i]-'0')
+				i++
+			}
+			i--
+			if n > 255 {
+				panic("decimal escape too large")
+			}
+			b.WriteByte(byte(n))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package glua
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// BenchmarkScripts runs the scripts of the bench directory under both
+// engines, print does nothing so that their output stays out of the way.
+func BenchmarkScripts(b *testing.B) {
+	files, err := filepath.Glob("../bench/*.lua")
+	if err != nil || len(files) == 0 {
+		b.Fatal("no scripts in ../bench")
+	}
+	engines := []struct {
+		name string
+		e    Engine
+	}{{"vm", VM}, {"tree", TreeWalker}}
+	for _, file := range files {
+		for _, e := range engines {
+			name := strings.TrimSuffix(filepath.Base(file), ".lua") + "/" + e.name
+			b.Run(name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					L := NewState()
+					L.SetEngine(e.e)
+					L.RegisterFunc("print", func(args ...interface{}) ([]interface{}, error) {
+						return nil, nil
+					})
+					if err := L.DoFile(file); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
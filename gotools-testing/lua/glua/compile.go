@@ -0,0 +1,926 @@
+package glua
+
+import (
+	"fmt"
+	"math"
+)
+
+// The compiler turns the syntax tree of a function into a proto for the
+// virtual machine. Local variables live in registers, the local i of the
+// active ones being in register i, and temporaries are taken above them.
+// A local that a closure captures is an upvalue: the closure points to its
+// register until the block of the local ends and CLOSE gives it a copy.
+
+// compileError is raised when a function does not fit in the instruction
+// format.
+type compileError struct {
+	line int
+	msg  string
+}
+
+// compile compiles the main function of a chunk.
+func compile(f *FuncExpr) (p *proto, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(compileError)
+			if !ok {
+				panic(r)
+			}
+			msg := fmt.Sprintf("%s:%d: %s", f.Chunk, e.line, e.msg)
+			err = &Error{Value: msg, msg: msg}
+		}
+	}()
+	return newCompiler(nil, f).function(), nil
+}
+
+// compiler compiles one function.
+type compiler struct {
+	parent *compiler
+	f      *FuncExpr
+	p      *proto
+	// actives holds the names of the active locals, innermost last
+	actives []string
+	// free is the first free register
+	free   int
+	block  *blockScope
+	consts map[interface{}]int
+	// line is the line of the instructions being emitted
+	line int
+}
+
+// blockScope is a block being compiled.
+type blockScope struct {
+	parent *blockScope
+	// nactive is the number of active locals when the block was entered
+	nactive int
+	// upval is set if a closure captures a local of the block
+	upval bool
+	loop  bool
+	// needClose is set on a loop if a local inside it is captured, the
+	// jumps of break must then close its upvalues
+	needClose bool
+	breaks    []int
+}
+
+// floatKey stands for a float in the constant cache, so that 0.0 and -0.0
+// are different constants.
+type floatKey uint64
+
+func newCompiler(parent *compiler, f *FuncExpr) *compiler {
+	return &compiler{
+		parent: parent,
+		f:      f,
+		p: &proto{
+			source:    f.Chunk,
+			line:      f.Pos.Line,
+			numParams: len(f.Params),
+			vararg:    f.Vararg,
+			info:      map[int][2]string{},
+		},
+		consts: map[interface{}]int{},
+		line:   f.Pos.Line,
+	}
+}
+
+func (c *compiler) function() *proto {
+	c.enterBlock(false)
+	c.actives = append(c.actives, c.f.Params...)
+	c.reserve(len(c.f.Params))
+	c.stats(c.f.Block.Stats)
+	c.emitABC(opRETURN, 0, 1, 0)
+	return c.p
+}
+
+func (c *compiler) fail(msg string) {
+	panic(compileError{c.line, msg})
+}
+
+// ---
+
+func (c *compiler) emit(i instr) int {
+	c.p.code = append(c.p.code, i)
+	c.p.lines = append(c.p.lines, int32(c.line))
+	return len(c.p.code) - 1
+}
+
+func (c *compiler) emitABC(op opcode, a, b, cc int) int {
+	return c.emit(iABC(op, a, b, cc))
+}
+
+func (c *compiler) emitABx(op opcode, a, bx int) int {
+	if bx > maxArgBx {
+		c.fail("too many constants")
+	}
+	return c.emit(iABx(op, a, bx))
+}
+
+// emitJump emits a jump to be patched later.
+func (c *compiler) emitJump() int {
+	return c.emit(iAsBx(opJMP, 0, 0))
+}
+
+// here returns the position of the next instruction.
+func (c *compiler) here() int {
+	return len(c.p.code)
+}
+
+// patch makes the jump at pc go to target.
+func (c *compiler) patch(pc, target int) {
+	off := target - (pc + 1)
+	if off > maxArgSBx || off < -maxArgSBx {
+		c.fail("control structure too long")
+	}
+	i := c.p.code[pc]
+	c.p.code[pc] = iAsBx(i.op(), i.a(), off)
+}
+
+func (c *compiler) patchList(jumps []int, target int) {
+	for _, pc := range jumps {
+		c.patch(pc, target)
+	}
+}
+
+// reserve takes n registers.
+func (c *compiler) reserve(n int) {
+	c.free += n
+	if c.free > maxRegs {
+		c.fail("function or expression needs too many registers")
+	}
+	if c.free > c.p.maxStack {
+		c.p.maxStack = c.free
+	}
+}
+
+// constant returns the index of v in the constants.
+func (c *compiler) constant(v interface{}) int {
+	k := v
+	if f, ok := v.(float64); ok {
+		k = floatKey(math.Float64bits(f))
+	}
+	if i, ok := c.consts[k]; ok {
+		return i
+	}
+	c.p.consts = append(c.p.consts, v)
+	c.consts[k] = len(c.p.consts) - 1
+	return len(c.p.consts) - 1
+}
+
+// rk returns the operand for the constant v, it is loaded in a register if
+// there are too many constants.
+func (c *compiler) rk(v interface{}) int {
+	k := c.constant(v)
+	if k <= maxIndexRK {
+		return k | bitRK
+	}
+	r := c.free
+	c.reserve(1)
+	c.emitABx(opLOADK, r, k)
+	return r
+}
+
+// ---
+
+func (c *compiler) enterBlock(loop bool) *blockScope {
+	c.block = &blockScope{parent: c.block, nactive: len(c.actives), loop: loop}
+	return c.block
+}
+
+// leaveBlock ends the current block, its locals are closed if a closure
+// captured one of them.
+func (c *compiler) leaveBlock() {
+	bl := c.block
+	if bl.upval && bl.parent != nil {
+		c.emitABC(opCLOSE, bl.nactive, 0, 0)
+	}
+	c.actives = c.actives[:bl.nactive]
+	c.free = bl.nactive
+	c.block = bl.parent
+}
+
+// leaveLoop ends the loop block bl, which has already been left, at the
+// current position: break goes here.
+func (c *compiler) leaveLoop(bl *blockScope) {
+	c.patchList(bl.breaks, c.here())
+	if bl.needClose && len(bl.breaks) > 0 {
+		c.emitABC(opCLOSE, bl.nactive, 0, 0)
+	}
+}
+
+// addLocals activates the locals names, their registers must be the next
+// ones.
+func (c *compiler) addLocals(names ...string) {
+	c.actives = append(c.actives, names...)
+	c.free = len(c.actives)
+}
+
+// findLocal returns the register of the local name, or -1.
+func (c *compiler) findLocal(name string) int {
+	for i := len(c.actives) - 1; i >= 0; i-- {
+		if c.actives[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// findUpval returns the index of the upvalue name, adding it if an
+// enclosing function has such a variable, or -1 for a global.
+func (c *compiler) findUpval(name string) int {
+	for i, u := range c.p.upvals {
+		if u.name == name {
+			return i
+		}
+	}
+	if c.parent == nil {
+		return -1
+	}
+	if r := c.parent.findLocal(name); r >= 0 {
+		c.parent.capture(r)
+		return c.addUpval(upvalDesc{name: name, inStack: true, index: r})
+	}
+	if i := c.parent.findUpval(name); i >= 0 {
+		return c.addUpval(upvalDesc{name: name, index: i})
+	}
+	return -1
+}
+
+func (c *compiler) addUpval(u upvalDesc) int {
+	if len(c.p.upvals) > maxArgB {
+		c.fail("too many upvalues")
+	}
+	c.p.upvals = append(c.p.upvals, u)
+	return len(c.p.upvals) - 1
+}
+
+// capture marks the block of the local in register r as having upvalues.
+func (c *compiler) capture(r int) {
+	bl := c.block
+	for bl.nactive > r {
+		bl = bl.parent
+	}
+	bl.upval = true
+	for ; bl != nil; bl = bl.parent {
+		if bl.loop {
+			bl.needClose = true
+		}
+	}
+}
+
+// varInfo describes the variable e for error messages, e must have been
+// compiled already.
+func (c *compiler) varInfo(e Expr) string {
+	switch e := e.(type) {
+	case *NameExpr:
+		kind := "global"
+		if c.findLocal(e.Name) >= 0 {
+			kind = "local"
+		} else {
+			for _, u := range c.p.upvals {
+				if u.name == e.Name {
+					kind = "upvalue"
+				}
+			}
+		}
+		return fmt.Sprintf(" (%s '%s')", kind, e.Name)
+	case *IndexExpr:
+		if k, ok := e.Key.(*ConstExpr); ok {
+			if s, ok := k.Value.(string); ok {
+				return fmt.Sprintf(" (field '%s')", s)
+			}
+		}
+	}
+	return ""
+}
+
+func (c *compiler) setInfo(pc int, info ...string) {
+	var names [2]string
+	copy(names[:], info)
+	if names != [2]string{} {
+		c.p.info[pc] = names
+	}
+}
+
+// ---
+
+func (c *compiler) subBlock(b *Block) {
+	c.enterBlock(false)
+	c.stats(b.Stats)
+	c.leaveBlock()
+}
+
+func (c *compiler) stats(stats []Stat) {
+	for _, s := range stats {
+		c.stat(s)
+		c.free = len(c.actives)
+	}
+}
+
+func (c *compiler) stat(s Stat) {
+	switch s := s.(type) {
+	case *ExprStat:
+		c.line = s.Pos.Line
+		if call, ok := s.Expr.(*CallExpr); ok {
+			c.call(call, 0)
+		} else {
+			c.exprNext(s.Expr)
+		}
+	case *LocalStat:
+		c.line = s.Pos.Line
+		base := c.free
+		if len(s.Exprs) == 0 {
+			c.emitABC(opLOADNIL, base, len(s.Names)-1, 0)
+			c.reserve(len(s.Names))
+		} else {
+			c.exprList(s.Exprs, len(s.Names))
+		}
+		c.addLocals(s.Names...)
+	case *LocalFuncStat:
+		c.line = s.Pos.Line
+		r := c.free
+		c.reserve(1)
+		c.addLocals(s.Name)
+		c.closure(s.Func, r)
+	case *AssignStat:
+		c.assign(s)
+	case *IfStat:
+		var exits []int
+		for i, cond := range s.Conds {
+			c.line = s.Pos.Line
+			next := c.cond(cond, false)
+			c.subBlock(s.Blocks[i])
+			if i < len(s.Conds)-1 || s.Else != nil {
+				exits = append(exits, c.emitJump())
+			}
+			c.patchList(next, c.here())
+		}
+		if s.Else != nil {
+			c.subBlock(s.Else)
+		}
+		c.patchList(exits, c.here())
+	case *DoStat:
+		c.subBlock(s.Block)
+	case *WhileStat:
+		c.line = s.Pos.Line
+		start := c.here()
+		exit := c.cond(s.Cond, false)
+		bl := c.enterBlock(true)
+		c.stats(s.Block.Stats)
+		c.leaveBlock()
+		c.line = s.Pos.Line
+		c.patch(c.emitJump(), start)
+		c.patchList(exit, c.here())
+		c.leaveLoop(bl)
+	case *RepeatStat:
+		c.repeat(s)
+	case *NumForStat:
+		c.numFor(s)
+	case *GenForStat:
+		c.genFor(s)
+	case *ReturnStat:
+		c.line = s.Pos.Line
+		c.ret(s.Exprs)
+	case *BreakStat:
+		c.line = s.Pos.Line
+		bl := c.block
+		for !bl.loop {
+			bl = bl.parent
+		}
+		bl.breaks = append(bl.breaks, c.emitJump())
+	default:
+		panic("unknown statement")
+	}
+}
+
+// assign compiles an assignment the way the evaluator runs it: the tables
+// and keys of the targets first, then all the values, then the stores from
+// left to right.
+func (c *compiler) assign(s *AssignStat) {
+	c.line = s.Pos.Line
+	if len(s.Lhs) == 1 && len(s.Rhs) == 1 {
+		if n, ok := s.Lhs[0].(*NameExpr); ok {
+			if r := c.findLocal(n.Name); r >= 0 {
+				c.expr(s.Rhs[0], r)
+				return
+			}
+		}
+		t := c.target(s.Lhs[0], nil)
+		c.line = s.Pos.Line
+		c.store(t, c.exprAny(s.Rhs[0]))
+		return
+	}
+	// locals written by the assignment, a target that reads one of them
+	// takes a copy
+	written := map[int]bool{}
+	for _, e := range s.Lhs {
+		if n, ok := e.(*NameExpr); ok {
+			if r := c.findLocal(n.Name); r >= 0 {
+				written[r] = true
+			}
+		}
+	}
+	ts := make([]lvalue, len(s.Lhs))
+	for i, e := range s.Lhs {
+		ts[i] = c.target(e, written)
+	}
+	c.line = s.Pos.Line
+	base := c.free
+	c.exprList(s.Rhs, len(s.Lhs))
+	for i, t := range ts {
+		c.store(t, base+i)
+	}
+}
+
+// lvalue is the target of an assignment, obj and key are the operands of
+// the table and the key of an index.
+type lvalue struct {
+	e        Expr
+	obj, key int
+}
+
+// target evaluates the table and the key of e.
+func (c *compiler) target(e Expr, written map[int]bool) lvalue {
+	t := lvalue{e: e}
+	switch e := e.(type) {
+	case *NameExpr:
+	case *IndexExpr:
+		t.obj = c.keep(c.exprAny(e.Obj), written)
+		t.key = c.keep(c.exprRK(e.Key), written)
+	default:
+		c.fail("cannot assign to expression")
+	}
+	return t
+}
+
+// keep copies the operand r to a new register if it is a local in written.
+func (c *compiler) keep(r int, written map[int]bool) int {
+	if r&bitRK != 0 || !written[r] {
+		return r
+	}
+	n := c.free
+	c.reserve(1)
+	c.emitABC(opMOVE, n, r, 0)
+	return n
+}
+
+// store assigns the register v to the target t.
+func (c *compiler) store(t lvalue, v int) {
+	switch e := t.e.(type) {
+	case *NameExpr:
+		if r := c.findLocal(e.Name); r >= 0 {
+			c.emitABC(opMOVE, r, v, 0)
+		} else if u := c.findUpval(e.Name); u >= 0 {
+			c.emitABC(opSETUPVAL, v, u, 0)
+		} else {
+			c.emitABx(opSETGLOBAL, v, c.constant(e.Name))
+		}
+	case *IndexExpr:
+		c.line = e.Pos.Line
+		c.setInfo(c.emitABC(opSETTABLE, t.obj, t.key, v), c.varInfo(e.Obj))
+	}
+}
+
+func (c *compiler) repeat(s *RepeatStat) {
+	c.line = s.Pos.Line
+	start := c.here()
+	bl := c.enterBlock(true)
+	c.stats(s.Block.Stats)
+	// the condition sees the locals of the block, they are closed on both
+	// ways out of it
+	exit := c.cond(s.Cond, true)
+	if bl.upval {
+		c.emitABC(opCLOSE, bl.nactive, 0, 0)
+	}
+	c.patch(c.emitJump(), start)
+	c.patchList(exit, c.here())
+	c.leaveBlock()
+	c.leaveLoop(bl)
+}
+
+// numFor compiles a numeric for, its three hidden locals hold the state of
+// the loop and the variable follows them.
+func (c *compiler) numFor(s *NumForStat) {
+	c.line = s.Pos.Line
+	c.enterBlock(false)
+	base := c.free
+	for i, e := range []Expr{s.Start, s.Limit, s.Step} {
+		r := c.free
+		c.reserve(1)
+		if e == nil {
+			c.emitABx(opLOADK, r, c.constant(int64(1)))
+			continue
+		}
+		c.expr(e, r)
+		if k, ok := e.(*ConstExpr); !ok || !isNumber(k.Value) {
+			c.line = s.Pos.Line
+			c.emitABC(opFORCHECK, r, 0, i)
+		}
+	}
+	c.addLocals("(for state)", "(for limit)", "(for step)")
+	c.line = s.Pos.Line
+	prep := c.emit(iAsBx(opFORPREP, base, 0))
+	body := c.here()
+	bl := c.enterBlock(true)
+	c.reserve(1)
+	c.addLocals(s.Name)
+	c.stats(s.Block.Stats)
+	c.leaveBlock()
+	c.line = s.Pos.Line
+	c.emit(iAsBx(opFORLOOP, base, body-(c.here()+1)))
+	c.patch(prep, c.here())
+	c.leaveLoop(bl)
+	c.leaveBlock()
+}
+
+// genFor compiles a generic for, the iterator function, its state and
+// the control variable are hidden locals followed by the variables.
+func (c *compiler) genFor(s *GenForStat) {
+	c.line = s.Pos.Line
+	c.enterBlock(false)
+	base := c.free
+	c.exprList(s.Exprs, 3)
+	c.addLocals("(for generator)", "(for state)", "(for control)")
+	c.line = s.Pos.Line
+	prep := c.emitJump()
+	body := c.here()
+	bl := c.enterBlock(true)
+	c.reserve(len(s.Names))
+	c.addLocals(s.Names...)
+	c.stats(s.Block.Stats)
+	c.leaveBlock()
+	c.line = s.Pos.Line
+	c.patch(prep, c.here())
+	c.emitABC(opTFORCALL, base, 0, len(s.Names))
+	c.emit(iAsBx(opTFORLOOP, base+2, body-(c.here()+1)))
+	c.leaveLoop(bl)
+	c.leaveBlock()
+}
+
+func (c *compiler) ret(es []Expr) {
+	if len(es) == 1 {
+		switch e := es[0].(type) {
+		case *CallExpr:
+			base := c.call(e, -1)
+			c.emitABC(opRETURN, base, 0, 0)
+			return
+		case *NameExpr:
+			if r := c.findLocal(e.Name); r >= 0 {
+				c.emitABC(opRETURN, r, 2, 0)
+				return
+			}
+		}
+	}
+	base := c.free
+	if c.exprList(es, -1) {
+		c.emitABC(opRETURN, base, 0, 0)
+	} else {
+		c.emitABC(opRETURN, base, c.free-base+1, 0)
+	}
+}
+
+// ---
+
+// isMulti reports whether e can have several values.
+func isMulti(e Expr) bool {
+	switch e.(type) {
+	case *CallExpr, *VarargExpr:
+		return true
+	}
+	return false
+}
+
+// exprList compiles es into the next registers. If want is -1 a call or
+// ... at the end keeps all its values and open is true, otherwise the
+// values are adjusted to want registers.
+func (c *compiler) exprList(es []Expr, want int) (open bool) {
+	base := c.free
+	for i, e := range es {
+		if i == len(es)-1 && isMulti(e) {
+			n := -1
+			if want >= 0 {
+				n = want - i
+				if n < 0 {
+					n = 0
+				}
+			}
+			c.multi(e, n)
+			if n < 0 {
+				return true
+			}
+			break
+		}
+		c.exprNext(e)
+	}
+	if want >= 0 {
+		if have := c.free - base; have < want {
+			c.emitABC(opLOADNIL, c.free, want-have-1, 0)
+			c.reserve(want - have)
+		}
+		c.free = base + want
+	}
+	return false
+}
+
+// multi compiles the call or ... e into the next n registers, or leaves
+// all its values on the top if n is -1.
+func (c *compiler) multi(e Expr, n int) {
+	switch e := e.(type) {
+	case *CallExpr:
+		c.call(e, n)
+	case *VarargExpr:
+		c.emitABC(opVARARG, c.free, n+1, 0)
+	}
+	if n > 0 {
+		c.reserve(n)
+	}
+}
+
+// call compiles the call e with the function in the next register, which
+// is returned, and keeps want results there, all of them if want is -1.
+func (c *compiler) call(e *CallExpr, want int) int {
+	base := c.free
+	info := ""
+	if e.Method != "" {
+		obj := c.exprAny(e.Func)
+		c.free = base
+		c.reserve(2)
+		key := c.rk(e.Method)
+		c.line = e.Pos.Line
+		c.setInfo(c.emitABC(opSELF, base, obj, key), c.varInfo(e.Func))
+		c.free = base + 2
+		info = fmt.Sprintf(" (method '%s')", e.Method)
+	} else {
+		c.exprNext(e.Func)
+		info = c.varInfo(e.Func)
+	}
+	b := 0
+	if !c.exprList(e.Args, -1) {
+		b = c.free - base
+	}
+	c.line = e.Pos.Line
+	c.setInfo(c.emitABC(opCALL, base, b, want+1), info)
+	c.free = base
+	return base
+}
+
+// exprNext compiles e into a new register.
+func (c *compiler) exprNext(e Expr) int {
+	r := c.free
+	c.reserve(1)
+	c.expr(e, r)
+	return r
+}
+
+// exprAny returns a register holding the value of e, a local is used in
+// place.
+func (c *compiler) exprAny(e Expr) int {
+	if n, ok := e.(*NameExpr); ok {
+		if r := c.findLocal(n.Name); r >= 0 {
+			return r
+		}
+	}
+	return c.exprNext(e)
+}
+
+// exprRK returns an operand holding the value of e.
+func (c *compiler) exprRK(e Expr) int {
+	if k, ok := e.(*ConstExpr); ok {
+		if i := c.constant(k.Value); i <= maxIndexRK {
+			return i | bitRK
+		}
+	}
+	return c.exprAny(e)
+}
+
+// expr compiles e into the register dst, which is either a local or the
+// last register taken. Only the last instruction writes to a local, so
+// that e sees its old value.
+func (c *compiler) expr(e Expr, dst int) {
+	top := c.free
+	defer func() { c.free = top }()
+	// some expressions are built in their register, they go to a new one
+	// first if dst is a local
+	inPlace := dst == top-1 && dst >= len(c.actives)
+	switch e := e.(type) {
+	case *ConstExpr:
+		switch v := e.Value.(type) {
+		case nil:
+			c.emitABC(opLOADNIL, dst, 0, 0)
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			c.emitABC(opLOADBOOL, dst, b, 0)
+		default:
+			c.emitABx(opLOADK, dst, c.constant(v))
+		}
+	case *NameExpr:
+		if r := c.findLocal(e.Name); r >= 0 {
+			if r != dst {
+				c.emitABC(opMOVE, dst, r, 0)
+			}
+		} else if u := c.findUpval(e.Name); u >= 0 {
+			c.emitABC(opGETUPVAL, dst, u, 0)
+		} else {
+			c.emitABx(opGETGLOBAL, dst, c.constant(e.Name))
+		}
+	case *ParenExpr:
+		c.expr(e.Expr, dst)
+	case *VarargExpr:
+		c.emitABC(opVARARG, dst, 2, 0)
+	case *FuncExpr:
+		c.closure(e, dst)
+	case *IndexExpr:
+		obj := c.exprAny(e.Obj)
+		key := c.exprRK(e.Key)
+		c.line = e.Pos.Line
+		c.setInfo(c.emitABC(opGETTABLE, dst, obj, key), c.varInfo(e.Obj))
+	case *TableExpr:
+		if !inPlace {
+			c.emitABC(opMOVE, dst, c.exprNext(e), 0)
+			return
+		}
+		c.table(e, dst)
+	case *CallExpr:
+		if !inPlace {
+			c.emitABC(opMOVE, dst, c.exprNext(e), 0)
+			return
+		}
+		c.free = dst
+		c.call(e, 1)
+	case *UnOpExpr:
+		r := c.exprAny(e.Expr)
+		c.line = e.Pos.Line
+		c.setInfo(c.emitABC(unOpcodes[e.Op], dst, r, 0), c.varInfo(e.Expr))
+	case *BinOpExpr:
+		switch e.Op {
+		case AND, OR:
+			if !inPlace {
+				c.emitABC(opMOVE, dst, c.exprNext(e), 0)
+				return
+			}
+			c.expr(e.Lhs, dst)
+			// and keeps a false left side, or a true one
+			keep := 0
+			if e.Op == OR {
+				keep = 1
+			}
+			c.emitABC(opTEST, dst, 0, keep)
+			j := c.emitJump()
+			c.expr(e.Rhs, dst)
+			c.patch(j, c.here())
+		case EQ, NE, LT, LE, GT, GE:
+			jumps := c.cond(e, true)
+			c.emitABC(opLOADBOOL, dst, 0, 1)
+			c.patchList(jumps, c.here())
+			c.emitABC(opLOADBOOL, dst, 1, 0)
+		default:
+			b := c.exprRK(e.Lhs)
+			cc := c.exprRK(e.Rhs)
+			c.line = e.Pos.Line
+			c.setInfo(c.emitABC(binOpcodes[e.Op], dst, b, cc), c.varInfo(e.Lhs), c.varInfo(e.Rhs))
+		}
+	default:
+		panic("unknown expression")
+	}
+}
+
+var unOpcodes = map[int]opcode{
+	NOT: opNOT,
+	'-': opUNM,
+	'#': opLEN,
+	'~': opBNOT,
+}
+
+var binOpcodes = map[int]opcode{
+	'+':       opADD,
+	'-':       opSUB,
+	'*':       opMUL,
+	'%':       opMOD,
+	'^':       opPOW,
+	'/':       opDIV,
+	IDIV:      opIDIV,
+	'&':       opBAND,
+	'|':       opBOR,
+	'~':       opBXOR,
+	SHL:       opSHL,
+	SHR:       opSHR,
+	StrAppend: opCONCAT,
+}
+
+// cond compiles e for a test and returns the jumps taken when the truth of
+// e is jumpIf, the code falls through otherwise.
+func (c *compiler) cond(e Expr, jumpIf bool) []int {
+	top := c.free
+	defer func() { c.free = top }()
+	switch e := e.(type) {
+	case *ConstExpr:
+		if truthy(e.Value) == jumpIf {
+			return []int{c.emitJump()}
+		}
+		return nil
+	case *ParenExpr:
+		return c.cond(e.Expr, jumpIf)
+	case *UnOpExpr:
+		if e.Op == NOT {
+			return c.cond(e.Expr, !jumpIf)
+		}
+	case *BinOpExpr:
+		switch e.Op {
+		case AND, OR:
+			// a and b is false as soon as a is, a or b is true as soon as a is
+			short := e.Op == OR
+			if jumpIf == short {
+				return append(c.cond(e.Lhs, short), c.cond(e.Rhs, short)...)
+			}
+			skip := c.cond(e.Lhs, short)
+			jumps := c.cond(e.Rhs, jumpIf)
+			c.patchList(skip, c.here())
+			return jumps
+		case EQ, NE, LT, LE, GT, GE:
+			b := c.exprRK(e.Lhs)
+			cc := c.exprRK(e.Rhs)
+			op, want := opEQ, jumpIf
+			switch e.Op {
+			case NE:
+				want = !jumpIf
+			case LT:
+				op = opLT
+			case LE:
+				op = opLE
+			case GT:
+				// a > b is b < a
+				op, b, cc = opLT, cc, b
+			case GE:
+				op, b, cc = opLE, cc, b
+			}
+			a := 0
+			if want {
+				a = 1
+			}
+			c.line = e.Pos.Line
+			c.emitABC(op, a, b, cc)
+			return []int{c.emitJump()}
+		}
+	}
+	r := c.exprAny(e)
+	t := 0
+	if jumpIf {
+		t = 1
+	}
+	c.emitABC(opTEST, r, 0, t)
+	return []int{c.emitJump()}
+}
+
+// closure compiles the function f and creates its closure in dst.
+func (c *compiler) closure(f *FuncExpr, dst int) {
+	child := newCompiler(c, f)
+	c.p.protos = append(c.p.protos, child.function())
+	c.emitABx(opCLOSURE, dst, len(c.p.protos)-1)
+}
+
+// table compiles the constructor e into dst, which is the last register
+// taken. The positional items are added at the end like the evaluator
+// does, so that they win over explicit keys.
+func (c *compiler) table(e *TableExpr, dst int) {
+	nhash := 0
+	for _, f := range e.Fields {
+		if f.Key != nil {
+			nhash++
+		}
+	}
+	if nhash > maxArgC {
+		nhash = maxArgC
+	}
+	c.emitABC(opNEWTABLE, dst, 0, nhash)
+	pending, items := 0, false
+	for i, f := range e.Fields {
+		if f.Key != nil {
+			top := c.free
+			k := c.exprRK(f.Key)
+			v := c.exprRK(f.Value)
+			c.line = e.Pos.Line
+			c.emitABC(opSETTABLE, dst, k, v)
+			c.free = top
+			continue
+		}
+		items = true
+		// flushing before the next item leaves at least one for the last
+		// SETLIST, whose B is 0 only after a call or ...
+		if pending == fieldsPerFlush {
+			c.emitABC(opSETLIST, dst, pending, 0)
+			c.free = dst + 1
+			pending = 0
+		}
+		if i == len(e.Fields)-1 && isMulti(f.Value) {
+			c.multi(f.Value, -1)
+			c.emitABC(opSETLIST, dst, 0, 1)
+			return
+		}
+		c.exprNext(f.Value)
+		pending++
+	}
+	if items {
+		c.emitABC(opSETLIST, dst, pending, 1)
+	}
+}
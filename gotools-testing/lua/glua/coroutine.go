@@ -0,0 +1,250 @@
+package glua
+
+import "runtime"
+
+// luaCoroutine is a Lua thread as scripts hold it. The goroutine of the
+// coroutine only refers to the thread, so that a suspended coroutine that
+// scripts dropped can be collected: the finalizer of its luaCoroutine
+// stops the goroutine.
+type luaCoroutine struct {
+	*thread
+}
+
+// thread is the state of a coroutine. Every coroutine runs its function in
+// its own goroutine, but only one of them runs at a time: resume hands
+// control to the coroutine and waits until it yields, returns or fails.
+// The goroutine of a coroutine that never finishes is stopped when the
+// coroutine is collected, or by State.Close.
+type thread struct {
+	fn     interface{}
+	status string // "suspended", "running", "normal" or "dead"
+	in     chan []interface{}
+	out    chan coResult
+
+	// the interpreter state of the coroutine while it is not running
+	stack []callInfo
+	pos   Pos
+	chunk string
+
+	// self is the luaCoroutine of the thread while it runs, for
+	// coroutine.running
+	self *luaCoroutine
+}
+
+// coResult is what a coroutine passes back to resume.
+type coResult struct {
+	vals   []interface{}
+	failed bool // vals holds the error value
+	unwind bool // vals holds a panic that goes past pcall
+	done   bool
+}
+
+func (L *state) newCoroutine(fn interface{}) *luaCoroutine {
+	// a script making many coroutines gets rid of the old ones here
+	L.mu.Lock()
+	L.closeDropped()
+	L.mu.Unlock()
+	co := &luaCoroutine{&thread{fn: fn, status: "suspended"}}
+	runtime.SetFinalizer(co, func(co *luaCoroutine) { L.drop(co.thread) })
+	return co
+}
+
+// drop stops the goroutine of the thread t, whose coroutine was collected.
+// Scripts may be running in the State: the goroutine would then unwind
+// concurrently with them, t is closed by them instead, when they make a
+// coroutine or return.
+func (L *state) drop(t *thread) {
+	L.mu.Lock()
+	defer L.mu.Unlock()
+	L.dropped = append(L.dropped, t)
+	if L.depth == 0 {
+		L.closeDropped()
+	}
+}
+
+// closeDropped closes the threads of the collected coroutines. L.mu must be
+// held, and no script running but in the calling goroutine.
+func (L *state) closeDropped() {
+	for _, t := range L.dropped {
+		if L.threads[t] {
+			t.close()
+			delete(L.threads, t)
+		}
+	}
+	L.dropped = nil
+}
+
+// busy runs fn, which runs scripts in the State. Only the main program
+// counts: a coroutine must not hold up the closing of the others while it
+// is suspended.
+func (L *state) busy(fn func()) {
+	if L.curCo != L.mainCo {
+		fn()
+		return
+	}
+	L.mu.Lock()
+	L.depth++
+	L.mu.Unlock()
+	defer func() {
+		L.mu.Lock()
+		defer L.mu.Unlock()
+		if L.depth--; L.depth == 0 {
+			L.closeDropped()
+		}
+	}()
+	fn()
+}
+
+// resume runs co until it yields or finishes. ok is false if co could not
+// be resumed or raised an error, which is then the only value.
+func (L *state) resume(handle *luaCoroutine, args []interface{}) (ok bool, vs []interface{}) {
+	co := handle.thread
+	switch co.status {
+	case "dead":
+		return false, []interface{}{"cannot resume dead coroutine"}
+	case "running", "normal":
+		return false, []interface{}{"cannot resume non-suspended coroutine"}
+	}
+	if co.in == nil {
+		co.in = make(chan []interface{})
+		co.out = make(chan coResult)
+		L.threads[co] = true
+		go co.run(L)
+	}
+
+	prev := L.curCo
+	stack, pos, chunk := L.callStack, L.curPos, L.curChunk
+	prev.status = "normal"
+	co.status = "running"
+	co.self = handle
+	L.curCo = co
+	L.callStack, L.curPos, L.curChunk = co.stack, co.pos, co.chunk
+
+	co.in <- args
+	r := <-co.out
+
+	co.stack, co.pos, co.chunk = L.callStack, L.curPos, L.curChunk
+	L.callStack, L.curPos, L.curChunk = stack, pos, chunk
+	L.curCo = prev
+	prev.status = "running"
+	co.status = "suspended"
+	co.self = nil
+	if r.done {
+		co.status = "dead"
+		co.stack = nil
+		delete(L.threads, co)
+	}
+	if r.unwind {
+		panic(r.vals[0])
+	}
+	return !r.failed, r.vals
+}
+
+// run is the body of the goroutine of co.
+func (co *thread) run(L *state) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(closing); ok {
+				co.out <- coResult{done: true}
+				return
+			}
+			if unwinding(r) {
+				co.out <- coResult{vals: []interface{}{r}, unwind: true, done: true}
+				return
+			}
+			co.out <- coResult{vals: []interface{}{L.errorValue(r)}, failed: true, done: true}
+		}
+	}()
+	args := <-co.in
+	rs := L.call(co.fn, args...)
+	co.out <- coResult{vals: rs, done: true}
+}
+
+// yield suspends the running coroutine and returns the values passed to
+// the next resume.
+func (L *state) yield(vs []interface{}) []interface{} {
+	co := L.curCo
+	if co == L.mainCo {
+		die("attempt to yield from outside a coroutine")
+	}
+	co.out <- coResult{vals: vs}
+	vs, ok := <-co.in
+	if !ok {
+		panic(closing{})
+	}
+	return vs
+}
+
+// closing is raised in a suspended coroutine when it is collected or its
+// State is closed, it unwinds the goroutine of the coroutine past every pcall.
+type closing struct{}
+
+// close makes the suspended coroutine co dead and waits for its goroutine
+// to return.
+func (co *thread) close() {
+	close(co.in)
+	<-co.out
+	co.status = "dead"
+	co.stack = nil
+}
+
+// checkCoroutine returns the n-th argument of the builtin fname, which must
+// be a coroutine.
+func checkCoroutine(args []interface{}, n int, fname string) *luaCoroutine {
+	co, ok := argAt(args, n-1).(*luaCoroutine)
+	if !ok {
+		die("bad argument #%d to '%s' (coroutine expected)", n, fname)
+	}
+	return co
+}
+
+// openCoroutine builds the coroutine library.
+func (L *state) openCoroutine() *luaTable {
+	lib := map[string]luaFunc{
+		"create": func(L *state, args ...interface{}) []interface{} {
+			if !callable(argAt(args, 0)) {
+				die("bad argument #1 to 'create' (function expected)")
+			}
+			return []interface{}{L.newCoroutine(args[0])}
+		},
+		"resume": func(L *state, args ...interface{}) []interface{} {
+			co := checkCoroutine(args, 1, "resume")
+			ok, vs := L.resume(co, args[1:])
+			return append([]interface{}{ok}, vs...)
+		},
+		"yield": func(L *state, args ...interface{}) []interface{} {
+			return L.yield(args)
+		},
+		"status": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{checkCoroutine(args, 1, "status").status}
+		},
+		"wrap": func(L *state, args ...interface{}) []interface{} {
+			if !callable(argAt(args, 0)) {
+				die("bad argument #1 to 'wrap' (function expected)")
+			}
+			co := L.newCoroutine(args[0])
+			return []interface{}{newFunc(func(L *state, args ...interface{}) []interface{} {
+				ok, vs := L.resume(co, args)
+				if !ok {
+					v := vs[0]
+					if s, ok := v.(string); ok {
+						v = L.where(1) + s
+					}
+					panic(&luaError{v})
+				}
+				return vs
+			})}
+		},
+		"isyieldable": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.curCo != L.mainCo}
+		},
+		"running": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.curCo.self, L.curCo == L.mainCo}
+		},
+	}
+	t := newTable(0, len(lib))
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	return t
+}
@@ -0,0 +1,118 @@
+package glua
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// generators leaves ten generators suspended by breaking out of their
+// loops.
+const generators = `
+for i = 1, 10 do
+	local gen = coroutine.wrap(function()
+		while true do coroutine.yield(i) end
+	end)
+	for v in gen do break end
+end
+`
+
+// waitGoroutines waits until at most n goroutines are left.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left, want at most %d", runtime.NumGoroutine(), n)
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseStopsCoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	L := NewState()
+	if err := L.DoString(generators); err != nil {
+		t.Fatal(err)
+	}
+	if n := runtime.NumGoroutine(); n < before+10 {
+		t.Fatalf("%d goroutines after the generators, want at least %d", n, before+10)
+	}
+	L.Close()
+	waitGoroutines(t, before)
+	L.Close()
+}
+
+// Suspended coroutines that scripts drop are collected with their
+// goroutines while the State stays open.
+func TestCollectStopsCoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	L := NewState()
+	if err := L.DoString(generators); err != nil {
+		t.Fatal(err)
+	}
+	if n := runtime.NumGoroutine(); n < before+10 {
+		t.Fatalf("%d goroutines after the generators, want at least %d", n, before+10)
+	}
+	waitGoroutines(t, before)
+
+	// a script that makes many of them does not pile up their goroutines
+	L.RegisterFunc("goroutines", func(args ...interface{}) ([]interface{}, error) {
+		runtime.GC()
+		return []interface{}{runtime.NumGoroutine()}, nil
+	})
+	err := L.DoString(`
+		most = 0
+		for i = 1, 10000 do
+			local gen = coroutine.wrap(function()
+				while true do coroutine.yield(i) end
+			end)
+			gen()
+			if i % 1000 == 0 then most = math.max(most, goroutines()) end
+		end
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most := L.GetGlobal("most").(int64); most > int64(before+3000) {
+		t.Errorf("%d goroutines while the script ran, want at most %d", most, before+3000)
+	}
+	waitGoroutines(t, before)
+	runtime.KeepAlive(L)
+}
+
+func TestCloseUnwindsPcall(t *testing.T) {
+	before := runtime.NumGoroutine()
+	L := NewState()
+	after := false
+	L.RegisterFunc("after", func(args ...interface{}) ([]interface{}, error) {
+		after = true
+		return nil, nil
+	})
+	err := L.DoString(`
+		co = coroutine.create(function()
+			pcall(coroutine.yield)
+			after()
+		end)
+		coroutine.resume(co)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	L.Close()
+	waitGoroutines(t, before)
+	if after {
+		t.Error("the coroutine went on after pcall when closed")
+	}
+}
+
+func TestFinalizerStopsCoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if err := NewState().DoString(generators); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitGoroutines(t, before)
+}
@@ -0,0 +1,479 @@
+package glua
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A binary chunk starts with a header telling the version of the format,
+// the function follows. Integers are varints, numbers are 8 bytes little
+// endian, so a chunk can be loaded on any machine.
+const (
+	dumpSignature = "\x1bLua"
+	dumpVersion   = 0x53
+	// dumpFormat tells the instruction set, chunks of other
+	// implementations are rejected.
+	dumpFormat = 'g'
+	// dumpData catches chunks mangled by text conversions.
+	dumpData = "\x19\x93\r\n\x1a\n"
+)
+
+// tags of the constants
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagString
+)
+
+// dump returns the binary chunk of p. strip leaves out the source name,
+// the lines and the variable names, errors in the loaded function are
+// reported without them.
+func dump(p *proto, strip bool) []byte {
+	d := &dumper{strip: strip}
+	d.buf.WriteString(dumpSignature)
+	d.buf.WriteByte(dumpVersion)
+	d.buf.WriteByte(dumpFormat)
+	d.buf.WriteString(dumpData)
+	d.int(int(numOpcodes))
+	d.function(p)
+	return d.buf.Bytes()
+}
+
+type dumper struct {
+	buf   bytes.Buffer
+	strip bool
+}
+
+func (d *dumper) int(n int) {
+	var b [binary.MaxVarintLen64]byte
+	d.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (d *dumper) uint64(n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	d.buf.Write(b[:])
+}
+
+func (d *dumper) string(s string) {
+	d.int(len(s))
+	d.buf.WriteString(s)
+}
+
+func (d *dumper) bool(b bool) {
+	if b {
+		d.buf.WriteByte(1)
+	} else {
+		d.buf.WriteByte(0)
+	}
+}
+
+func (d *dumper) function(p *proto) {
+	if d.strip {
+		d.string("?")
+		d.int(0)
+	} else {
+		d.string(p.source)
+		d.int(p.line)
+	}
+	d.int(p.numParams)
+	d.bool(p.vararg)
+	d.int(p.maxStack)
+
+	d.int(len(p.code))
+	for _, i := range p.code {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(i))
+		d.buf.Write(b[:])
+	}
+
+	d.int(len(p.consts))
+	for _, k := range p.consts {
+		switch k := k.(type) {
+		case nil:
+			d.buf.WriteByte(tagNil)
+		case bool:
+			if k {
+				d.buf.WriteByte(tagTrue)
+			} else {
+				d.buf.WriteByte(tagFalse)
+			}
+		case int64:
+			d.buf.WriteByte(tagInt)
+			d.uint64(uint64(k))
+		case float64:
+			d.buf.WriteByte(tagFloat)
+			d.uint64(math.Float64bits(k))
+		case string:
+			d.buf.WriteByte(tagString)
+			d.string(k)
+		}
+	}
+
+	d.int(len(p.upvals))
+	for _, u := range p.upvals {
+		d.bool(u.inStack)
+		d.int(u.index)
+		if d.strip {
+			d.string("")
+		} else {
+			d.string(u.name)
+		}
+	}
+
+	d.int(len(p.protos))
+	for _, np := range p.protos {
+		d.function(np)
+	}
+
+	if d.strip {
+		d.int(0)
+		d.int(0)
+		return
+	}
+	d.int(len(p.lines))
+	for _, l := range p.lines {
+		d.int(int(l))
+	}
+	d.int(len(p.info))
+	for pc := range p.code {
+		if names, ok := p.info[pc]; ok {
+			d.int(pc)
+			d.string(names[0])
+			d.string(names[1])
+		}
+	}
+}
+
+// undump reads the binary chunk from r. A chunk that does not match the
+// header, or that could make the machine run out of its registers,
+// constants or code, is rejected.
+func undump(r io.Reader, name string) (p *proto, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	u := &undumper{b: b}
+	defer func() {
+		if e := recover(); e != nil {
+			why, ok := e.(chunkError)
+			if !ok {
+				panic(e)
+			}
+			msg := fmt.Sprintf("%s: %s precompiled chunk", name, why)
+			err = &Error{Value: msg, msg: msg}
+		}
+	}()
+	u.header()
+	p = u.function(nil)
+	if len(u.b) != 0 {
+		u.fail("corrupted")
+	}
+	return p, nil
+}
+
+type chunkError string
+
+type undumper struct {
+	b []byte
+}
+
+func (u *undumper) fail(why string) {
+	panic(chunkError(why))
+}
+
+func (u *undumper) header() {
+	if string(u.bytes(len(dumpSignature))) != dumpSignature {
+		u.fail("not a")
+	}
+	if u.byte() != dumpVersion {
+		u.fail("version mismatch in")
+	}
+	if u.byte() != dumpFormat {
+		u.fail("format mismatch in")
+	}
+	if string(u.bytes(len(dumpData))) != dumpData {
+		u.fail("corrupted")
+	}
+	if u.int() != int(numOpcodes) {
+		u.fail("format mismatch in")
+	}
+}
+
+func (u *undumper) bytes(n int) []byte {
+	if n > len(u.b) {
+		u.fail("truncated")
+	}
+	b := u.b[:n]
+	u.b = u.b[n:]
+	return b
+}
+
+func (u *undumper) byte() byte {
+	return u.bytes(1)[0]
+}
+
+// int reads a count or an index, which never come close to 2^31.
+func (u *undumper) int() int {
+	n, size := binary.Uvarint(u.b)
+	if size == 0 {
+		u.fail("truncated")
+	}
+	if size < 0 || n > math.MaxInt32 {
+		u.fail("corrupted")
+	}
+	u.b = u.b[size:]
+	return int(n)
+}
+
+// count reads the length of a list whose items take at least one byte
+// each, so that a corrupt length does not allocate a huge list.
+func (u *undumper) count() int {
+	n := u.int()
+	if n > len(u.b) {
+		u.fail("truncated")
+	}
+	return n
+}
+
+func (u *undumper) uint64() uint64 {
+	return binary.LittleEndian.Uint64(u.bytes(8))
+}
+
+func (u *undumper) string() string {
+	return string(u.bytes(u.int()))
+}
+
+func (u *undumper) bool() bool {
+	switch u.byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	u.fail("corrupted")
+	return false
+}
+
+func (u *undumper) function(parent *proto) *proto {
+	p := &proto{
+		source:    u.string(),
+		line:      u.int(),
+		numParams: u.int(),
+		vararg:    u.bool(),
+		maxStack:  u.int(),
+	}
+	if p.maxStack > maxRegs || p.numParams > p.maxStack {
+		u.fail("corrupted")
+	}
+
+	p.code = make([]instr, u.count())
+	for i := range p.code {
+		p.code[i] = instr(binary.LittleEndian.Uint32(u.bytes(4)))
+	}
+
+	p.consts = make([]interface{}, u.count())
+	for i := range p.consts {
+		switch u.byte() {
+		case tagNil:
+		case tagFalse:
+			p.consts[i] = false
+		case tagTrue:
+			p.consts[i] = true
+		case tagInt:
+			p.consts[i] = int64(u.uint64())
+		case tagFloat:
+			p.consts[i] = math.Float64frombits(u.uint64())
+		case tagString:
+			p.consts[i] = u.string()
+		default:
+			u.fail("corrupted")
+		}
+	}
+
+	p.upvals = make([]upvalDesc, u.count())
+	for i := range p.upvals {
+		d := upvalDesc{inStack: u.bool(), index: u.int(), name: u.string()}
+		if parent != nil && (d.inStack && d.index >= parent.maxStack ||
+			!d.inStack && d.index >= len(parent.upvals)) {
+			u.fail("corrupted")
+		}
+		p.upvals[i] = d
+	}
+
+	p.protos = make([]*proto, u.count())
+	for i := range p.protos {
+		p.protos[i] = u.function(p)
+	}
+
+	if n := u.count(); n == len(p.code) {
+		p.lines = make([]int32, n)
+		for i := range p.lines {
+			p.lines[i] = int32(u.int())
+		}
+	} else if n == 0 {
+		// stripped
+		p.lines = make([]int32, len(p.code))
+	} else {
+		u.fail("corrupted")
+	}
+	if n := u.count(); n > 0 {
+		p.info = make(map[int][2]string, n)
+		for i := 0; i < n; i++ {
+			pc := u.int()
+			p.info[pc] = [2]string{u.string(), u.string()}
+		}
+	}
+
+	if !p.verify() {
+		u.fail("corrupted")
+	}
+	return p
+}
+
+// verify checks that the operands of the instructions of p are within its
+// registers, constants, upvalues and code, and that it ends with a RETURN.
+// It also checks the instructions that depend on others the way the
+// compiler pairs them: the loop instructions, SETLIST and its NEWTABLE,
+// and the B of 0 that takes the results of the call or ... just before.
+func (p *proto) verify() bool {
+	n := len(p.code)
+	if n == 0 || p.code[n-1].op() != opRETURN {
+		return false
+	}
+	// targets marks the instructions that are jumped to
+	targets := make([]bool, n)
+	for pc, i := range p.code {
+		switch i.op() {
+		case opJMP, opFORPREP, opFORLOOP, opTFORLOOP:
+			if t := pc + 1 + i.sbx(); t >= 0 && t < n {
+				targets[t] = true
+			}
+		case opEQ, opLT, opLE, opTEST, opLOADBOOL:
+			if pc+2 < n && (i.op() != opLOADBOOL || i.c() != 0) {
+				targets[pc+2] = true
+			}
+		}
+	}
+	reg := func(r int) bool { return r < p.maxStack }
+	rk := func(x int) bool {
+		if x&bitRK != 0 {
+			return x&^bitRK < len(p.consts)
+		}
+		return reg(x)
+	}
+	jump := func(pc, sbx int) bool { return pc+1+sbx >= 0 && pc+1+sbx < n }
+	name := func(bx int) bool {
+		if bx >= len(p.consts) {
+			return false
+		}
+		_, ok := p.consts[bx].(string)
+		return ok
+	}
+	// open tells whether the instruction at pc can take all the results
+	// left from base on by the one before it
+	open := func(pc, base int) bool {
+		if pc == 0 || targets[pc] {
+			return false
+		}
+		prev := p.code[pc-1]
+		return (prev.op() == opCALL && prev.c() == 0 || prev.op() == opVARARG && prev.b() == 0) &&
+			prev.a() >= base
+	}
+	// table tells whether a NEWTABLE before pc makes a table in r
+	table := func(pc, r int) bool {
+		for pc--; pc >= 0; pc-- {
+			if i := p.code[pc]; i.op() == opNEWTABLE && i.a() == r {
+				return true
+			}
+		}
+		return false
+	}
+	is := func(pc int, op opcode, a int) bool {
+		return pc >= 0 && pc < n && p.code[pc].op() == op && p.code[pc].a() == a
+	}
+	for pc, i := range p.code {
+		a, b, c := i.a(), i.b(), i.c()
+		ok := reg(a)
+		switch op := i.op(); {
+		case op == opMOVE, op == opUNM, op == opBNOT, op == opNOT, op == opLEN:
+			ok = ok && reg(b)
+		case op == opLOADK:
+			ok = ok && i.bx() < len(p.consts)
+		case op == opLOADBOOL:
+			ok = ok && (c == 0 || pc+2 < n)
+		case op == opLOADNIL:
+			ok = reg(a + b)
+		case op == opGETUPVAL, op == opSETUPVAL:
+			ok = ok && b < len(p.upvals)
+		case op == opGETGLOBAL, op == opSETGLOBAL:
+			ok = ok && name(i.bx())
+		case op == opGETTABLE:
+			ok = ok && reg(b) && rk(c)
+		case op == opSETTABLE:
+			ok = ok && rk(b) && rk(c)
+		case op == opNEWTABLE:
+		case op == opSELF:
+			ok = reg(a+1) && reg(b) && rk(c)
+		case op >= opADD && op <= opCONCAT:
+			ok = ok && rk(b) && rk(c)
+		case op == opJMP:
+			ok = jump(pc, i.sbx())
+		case op == opEQ, op == opLT, op == opLE:
+			ok = rk(b) && rk(c) && pc+2 < n
+		case op == opTEST:
+			ok = ok && pc+2 < n
+		case op == opCALL:
+			ok = ok && (b == 0 && open(pc, a+1) || b > 0 && reg(a+b-1)) && (c == 0 || c == 1 || reg(a+c-2))
+		case op == opRETURN:
+			ok = a <= p.maxStack && (b == 0 && open(pc, a) || b == 1 || b > 1 && reg(a+b-2))
+		case op == opFORCHECK:
+			ok = ok && c < len(forErrors)
+		case op == opFORPREP:
+			// it jumps past its FORLOOP, which jumps back to the body
+			loop := pc + i.sbx()
+			ok = reg(a+3) && jump(pc, i.sbx()) && is(loop, opFORLOOP, a) && loop+1+p.code[loop].sbx() == pc+1
+		case op == opFORLOOP:
+			ok = reg(a+3) && jump(pc, i.sbx())
+			if prep := pc + i.sbx(); ok {
+				ok = is(prep, opFORPREP, a) && prep+p.code[prep].sbx() == pc
+			}
+		case op == opTFORCALL:
+			ok = reg(a+2+c) && is(pc+1, opTFORLOOP, a+2)
+		case op == opTFORLOOP:
+			ok = reg(a+1) && jump(pc, i.sbx()) && is(pc-1, opTFORCALL, a-2)
+		case op == opSETLIST:
+			ok = ok && (b == 0 && open(pc, a+1) || b > 0 && reg(a+b)) && table(pc, a)
+		case op == opCLOSURE:
+			ok = ok && i.bx() < len(p.protos)
+		case op == opVARARG:
+			ok = a <= p.maxStack && (b <= 1 || reg(a+b-2))
+		case op == opCLOSE:
+			ok = a <= p.maxStack
+		default:
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newClosure returns a closure of the loaded function p. Its upvalues
+// cannot be restored, they start as nil.
+func newClosure(p *proto) *luaClosure {
+	ups := make([]*upval, len(p.upvals))
+	for i := range ups {
+		u := &upval{}
+		u.p = &u.v
+		ups[i] = u
+	}
+	return &luaClosure{p: p, upvals: ups}
+}
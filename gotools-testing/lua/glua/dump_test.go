@@ -0,0 +1,143 @@
+package glua
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mutated is compiled, changed and dumped by the tests: it has the loop
+// instructions, a table constructor and calls that take open results.
+const mutated = `
+local t = {...}
+for i = 1, #t do t[i] = i end
+for k, v in pairs(t) do t[k] = v end
+print(...)
+return ...
+`
+
+// compileString compiles src and fails the test on an error.
+func compileString(t *testing.T, src string) *proto {
+	t.Helper()
+	fn, err := parseChunk(strings.NewReader(src), "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := compile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// change replaces the n-th instruction op of p by the result of f, n
+// counting from 0.
+func change(t *testing.T, p *proto, op opcode, n int, f func(i instr) instr) {
+	t.Helper()
+	for pc, i := range p.code {
+		if i.op() != op {
+			continue
+		}
+		if n == 0 {
+			p.code[pc] = f(i)
+			return
+		}
+		n--
+	}
+	t.Fatalf("no instruction %d in the chunk", op)
+}
+
+// loadError feeds chunk to load and returns the message, load must fail.
+func loadError(t *testing.T, chunk []byte) string {
+	t.Helper()
+	L := NewState()
+	L.SetGlobal("chunk", string(chunk))
+	if err := L.DoString(`f, msg = load(chunk)`); err != nil {
+		t.Fatal(err)
+	}
+	if f := L.GetGlobal("f"); f != nil {
+		t.Fatal("load accepted the chunk")
+	}
+	msg, _ := L.GetGlobal("msg").(string)
+	return msg
+}
+
+func TestLoadTruncated(t *testing.T) {
+	b := dump(compileString(t, mutated), false)
+	// the first byte makes it binary, without it the chunk is empty text
+	for n := 1; n < len(b); n++ {
+		if msg := loadError(t, b[:n]); msg == "" {
+			t.Fatalf("%d bytes: no message", n)
+		}
+	}
+}
+
+func TestLoadMutated(t *testing.T) {
+	tests := []struct {
+		name string
+		op   opcode
+		n    int
+		f    func(i instr) instr
+	}{
+		{"FORLOOP without FORPREP", opFORPREP, 0, func(i instr) instr {
+			return iAsBx(opJMP, 0, i.sbx())
+		}},
+		{"FORLOOP of another loop", opFORLOOP, 0, func(i instr) instr {
+			return iAsBx(opFORLOOP, i.a()+1, i.sbx())
+		}},
+		{"TFORLOOP without TFORCALL", opTFORCALL, 0, func(i instr) instr {
+			return iABC(opLOADNIL, i.a(), 0, 0)
+		}},
+		{"SETLIST without NEWTABLE", opNEWTABLE, 0, func(i instr) instr {
+			return iABC(opLOADNIL, i.a(), 0, 0)
+		}},
+		{"SETLIST on another register", opSETLIST, 0, func(i instr) instr {
+			return iABC(opSETLIST, i.a()+1, i.b(), i.c())
+		}},
+		{"SETLIST of all results without VARARG", opVARARG, 0, func(i instr) instr {
+			return iABC(opVARARG, i.a(), 2, 0)
+		}},
+		{"CALL of all results without VARARG", opVARARG, 1, func(i instr) instr {
+			return iABC(opVARARG, i.a(), 2, 0)
+		}},
+		{"RETURN of all results without VARARG", opVARARG, 2, func(i instr) instr {
+			return iABC(opLOADNIL, i.a(), 0, 0)
+		}},
+	}
+	for _, tt := range tests {
+		p := compileString(t, mutated)
+		change(t, p, tt.op, tt.n, tt.f)
+		if msg := loadError(t, dump(p, false)); !strings.Contains(msg, "corrupted precompiled chunk") {
+			t.Errorf("%s: message %q", tt.name, msg)
+		}
+	}
+}
+
+// TestRunMutated runs a chunk that verify cannot tell from a good one: the
+// VM raises an error instead of failing.
+func TestRunMutated(t *testing.T) {
+	p := compileString(t, `local n = ... for i = 1, n do end`)
+	change(t, p, opFORCHECK, 0, func(i instr) instr { return iABC(opMOVE, i.a(), i.a(), 0) })
+	L := NewState()
+	L.SetGlobal("chunk", string(dump(p, false)))
+	err := L.DoString(`load(chunk)("3")`)
+	if _, ok := err.(*Error); !ok || !strings.Contains(err.Error(), "corrupted precompiled chunk") {
+		t.Errorf("got %T %v, want a corrupted chunk error", err, err)
+	}
+}
+
+// TestVerifyCompiled checks that verify accepts what the compiler makes.
+func TestVerifyCompiled(t *testing.T) {
+	files, _ := filepath.Glob("../test/*.lua")
+	bench, _ := filepath.Glob("../bench/*.lua")
+	for _, name := range append(files, bench...) {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := undump(strings.NewReader(string(dump(compileString(t, string(src)), false))), name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
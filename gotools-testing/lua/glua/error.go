@@ -0,0 +1,123 @@
+package glua
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// luaError is an error raised by error() or assert(), its value is passed
+// to pcall as is. Any other panic is a runtime error and gets the position
+// it was raised at.
+type luaError struct {
+	value interface{}
+}
+
+// callInfo is an active call: the function and where it was called from.
+type callInfo struct {
+	fn    interface{}
+	pos   Pos
+	chunk string
+}
+
+// where returns the "chunk:line:" prefix of the function at the given
+// level, level 1 being the function that called the running builtin. It is
+// empty if there is no such function or it is not written in Lua.
+func (L *state) where(level int) string {
+	n := len(L.callStack)
+	i := n - 1 - level
+	if level < 1 || i < -1 || (i == -1 && L.curCo != L.mainCo) {
+		// only the main program has a Lua chunk below the first call
+		return ""
+	}
+	if i >= 0 {
+		if _, ok := L.callStack[i].fn.(*luaFunc); ok {
+			return ""
+		}
+	}
+	ci := L.callStack[i+1]
+	return fmt.Sprintf("%s:%d: ", ci.chunk, ci.pos.Line)
+}
+
+// unwinding reports whether the panic r goes past pcall: it is os.exit,
+// the closing of a suspended coroutine or a Go runtime error, which is a
+// bug of the interpreter or of Go code and not an error of the script.
+func unwinding(r interface{}) bool {
+	switch r.(type) {
+	case exitCode, closing, runtime.Error:
+		return true
+	}
+	return false
+}
+
+// errorValue converts a recovered panic to the Lua error value.
+func (L *state) errorValue(r interface{}) interface{} {
+	if e, ok := r.(*luaError); ok {
+		return e.value
+	}
+	var msg string
+	switch r := r.(type) {
+	case string:
+		msg = r
+	case error:
+		msg = r.Error()
+	default:
+		msg = fmt.Sprint(r)
+	}
+	if n := len(L.callStack); n > 0 {
+		if _, ok := L.callStack[n-1].fn.(*luaFunc); ok {
+			// raised by a builtin, blame the caller
+			return L.where(1) + msg
+		}
+	}
+	return fmt.Sprintf("%s:%d: %s", L.curChunk, L.curPos.Line, msg)
+}
+
+// errorString is the message shown for an error that was not caught.
+func (L *state) errorString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64, float64:
+		return numToStr(v)
+	}
+	if L.metaOf(v, "__tostring") != nil {
+		return L.tostr(v)
+	}
+	return fmt.Sprintf("(error object is a %s value)", valType(v))
+}
+
+// pcall calls fn in protected mode. If handler is not nil it is called
+// with the error value before the call stack is unwound.
+func (L *state) pcall(fn, handler interface{}, args []interface{}) (rs []interface{}) {
+	n, pos, chunk := len(L.callStack), L.curPos, L.curChunk
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if unwinding(r) {
+			panic(r)
+		}
+		v := L.errorValue(r)
+		if handler != nil {
+			v = L.handle(handler, v)
+		}
+		L.popCalls(n)
+		L.curPos, L.curChunk = pos, chunk
+		rs = []interface{}{false, v}
+	}()
+	return append([]interface{}{true}, L.call(fn, args...)...)
+}
+
+// handle runs the message handler of xpcall.
+func (L *state) handle(handler, v interface{}) (r interface{}) {
+	defer func() {
+		if e := recover(); e != nil {
+			if unwinding(e) {
+				panic(e)
+			}
+			r = L.errorValue(e)
+		}
+	}()
+	return first(L.call(handler, v))
+}
@@ -0,0 +1,634 @@
+package glua
+
+import (
+	"fmt"
+	"math"
+)
+
+// ctrl tells the enclosing statements how a block was left.
+type ctrl int
+
+const (
+	ctrlNone ctrl = iota
+	ctrlBreak
+	ctrlReturn
+)
+
+// frame is the state of a function call shared by all of its scopes.
+type frame struct {
+	varargs []interface{}
+	ret     []interface{}
+}
+
+// scope is a lexical block holding local variables, names that are not
+// found in any scope are globals.
+type scope struct {
+	vars   map[string]*interface{}
+	parent *scope
+	fr     *frame
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, fr: parent.fr}
+}
+
+// newCallScope returns the outermost scope of a function call.
+func newCallScope(env *scope, varargs []interface{}) *scope {
+	return &scope{parent: env, fr: &frame{varargs: varargs}}
+}
+
+// define declares a new local variable in this scope.
+func (sc *scope) define(name string, v interface{}) {
+	if sc.vars == nil {
+		sc.vars = map[string]*interface{}{}
+	}
+	sc.vars[name] = &v
+}
+
+// lookup returns the local variable called name, or nil for a global.
+func (sc *scope) lookup(name string) *interface{} {
+	for ; sc != nil; sc = sc.parent {
+		if p, ok := sc.vars[name]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// kind tells whether name is a local, an upvalue or a global in sc.
+func (sc *scope) kind(name string) string {
+	for s := sc; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			if s.fr == sc.fr {
+				return "local"
+			}
+			return "upvalue"
+		}
+	}
+	return "global"
+}
+
+func (L *state) execBlock(b *Block, sc *scope) ctrl {
+	c, _ := L.execStats(b, newScope(sc))
+	return c
+}
+
+// execStats runs the statements of b in sc and returns the innermost scope.
+// Every local statement opens a new scope for the rest of the block, so
+// that functions created before it cannot see the new variables.
+func (L *state) execStats(b *Block, sc *scope) (ctrl, *scope) {
+	for _, s := range b.Stats {
+		switch s := s.(type) {
+		case *LocalStat:
+			vs := L.evalList(s.Exprs, sc)
+			sc = newScope(sc)
+			for i, name := range s.Names {
+				var v interface{}
+				if i < len(vs) {
+					v = vs[i]
+				}
+				sc.define(name, v)
+			}
+		case *LocalFuncStat:
+			sc = newScope(sc)
+			sc.define(s.Name, nil)
+			*sc.lookup(s.Name) = &luaClosure{fn: s.Func, env: sc}
+		default:
+			if c := L.exec(s, sc); c != ctrlNone {
+				return c, sc
+			}
+		}
+	}
+	return ctrlNone, sc
+}
+
+func (L *state) exec(s Stat, sc *scope) ctrl {
+	switch s := s.(type) {
+	case *ExprStat:
+		L.eval(s.Expr, sc)
+	case *AssignStat:
+		// tables and keys are evaluated before any value is stored, so
+		// i, t[i] = i+1, 20 writes t[i] with the old i
+		ts := make([]target, len(s.Lhs))
+		for i, e := range s.Lhs {
+			ts[i] = L.evalTarget(e, sc)
+		}
+		rhs := L.evalList(s.Rhs, sc)
+		L.curPos = s.Pos
+		for i, t := range ts {
+			var v interface{}
+			if i < len(rhs) {
+				v = rhs[i]
+			}
+			L.assign(t, v, sc)
+		}
+	case *IfStat:
+		for i, cond := range s.Conds {
+			if truthy(L.eval(cond, sc)) {
+				return L.execBlock(s.Blocks[i], sc)
+			}
+		}
+		if s.Else != nil {
+			return L.execBlock(s.Else, sc)
+		}
+	case *DoStat:
+		return L.execBlock(s.Block, sc)
+	case *WhileStat:
+		for truthy(L.eval(s.Cond, sc)) {
+			if c := L.execBlock(s.Block, sc); c == ctrlBreak {
+				break
+			} else if c == ctrlReturn {
+				return c
+			}
+		}
+	case *RepeatStat:
+		for {
+			c, inner := L.execStats(s.Block, newScope(sc))
+			if c == ctrlBreak {
+				break
+			} else if c == ctrlReturn {
+				return c
+			}
+			if truthy(L.eval(s.Cond, inner)) {
+				break
+			}
+		}
+	case *NumForStat:
+		return L.execNumFor(s, sc)
+	case *GenForStat:
+		return L.execGenFor(s, sc)
+	case *ReturnStat:
+		sc.fr.ret = L.evalList(s.Exprs, sc)
+		return ctrlReturn
+	case *BreakStat:
+		return ctrlBreak
+	default:
+		panic("unknown statement")
+	}
+	return ctrlNone
+}
+
+func (L *state) execNumFor(s *NumForStat, sc *scope) ctrl {
+	start := coerce(L.eval(s.Start, sc))
+	if !isNumber(start) {
+		L.curPos = s.Pos
+		die("'for' initial value must be a number")
+	}
+	limit := coerce(L.eval(s.Limit, sc))
+	if !isNumber(limit) {
+		L.curPos = s.Pos
+		die("'for' limit must be a number")
+	}
+	var step interface{} = int64(1)
+	if s.Step != nil {
+		if step = coerce(L.eval(s.Step, sc)); !isNumber(step) {
+			L.curPos = s.Pos
+			die("'for' step must be a number")
+		}
+	}
+	if numEQ(step, int64(0)) {
+		L.curPos = s.Pos
+		die("'for' step is zero")
+	}
+	i0, ok1 := start.(int64)
+	di, ok2 := step.(int64)
+	if ok1 && ok2 {
+		n, skip := forLimit(limit, di)
+		if skip {
+			return ctrlNone
+		}
+		// count the iterations first so that i never overflows
+		var count uint64
+		if di > 0 {
+			if i0 > n {
+				return ctrlNone
+			}
+			count = (uint64(n) - uint64(i0)) / uint64(di)
+		} else {
+			if i0 < n {
+				return ctrlNone
+			}
+			count = (uint64(i0) - uint64(n)) / (uint64(-(di + 1)) + 1)
+		}
+		return L.forLoop(s, sc, func(yield func(interface{}) bool) {
+			for i := i0; yield(i) && count > 0; count-- {
+				i += di
+			}
+		})
+	}
+	f0, _ := toFloat(start)
+	fn, _ := toFloat(limit)
+	df, _ := toFloat(step)
+	return L.forLoop(s, sc, func(yield func(interface{}) bool) {
+		for i := f0; (df > 0 && i <= fn) || (df < 0 && i >= fn); i += df {
+			if !yield(i) {
+				return
+			}
+		}
+	})
+}
+
+// forLimit converts the limit of an integer loop to an integer, skip is
+// true if the loop must not run at all.
+func forLimit(limit interface{}, step int64) (n int64, skip bool) {
+	if n, ok := limit.(int64); ok {
+		return n, false
+	}
+	f := limit.(float64)
+	if math.IsNaN(f) {
+		return 0, true
+	}
+	if step > 0 {
+		f = math.Floor(f)
+	} else {
+		f = math.Ceil(f)
+	}
+	switch {
+	case f >= 1<<63:
+		return math.MaxInt64, step < 0
+	case f < -(1 << 63):
+		return math.MinInt64, step > 0
+	}
+	return int64(f), false
+}
+
+// forLoop runs the body of s once for every value produced by values.
+func (L *state) forLoop(s *NumForStat, sc *scope, values func(yield func(interface{}) bool)) ctrl {
+	c := ctrlNone
+	values(func(i interface{}) bool {
+		inner := newScope(sc)
+		inner.define(s.Name, i)
+		switch L.execBlock(s.Block, inner) {
+		case ctrlBreak:
+			return false
+		case ctrlReturn:
+			c = ctrlReturn
+			return false
+		}
+		return true
+	})
+	return c
+}
+
+func (L *state) execGenFor(s *GenForStat, sc *scope) ctrl {
+	vs := L.evalList(s.Exprs, sc)
+	for len(vs) < 3 {
+		vs = append(vs, nil)
+	}
+	f, state, control := vs[0], vs[1], vs[2]
+	for {
+		L.curPos = s.Pos
+		rs := L.call(f, state, control)
+		if len(rs) == 0 || rs[0] == nil {
+			break
+		}
+		control = rs[0]
+		inner := newScope(sc)
+		for i, name := range s.Names {
+			var v interface{}
+			if i < len(rs) {
+				v = rs[i]
+			}
+			inner.define(name, v)
+		}
+		if c := L.execBlock(s.Block, inner); c == ctrlBreak {
+			break
+		} else if c == ctrlReturn {
+			return c
+		}
+	}
+	return ctrlNone
+}
+
+// truthy reports whether v counts as true, only nil and false are false.
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+// target is the left-hand side of an assignment with its table and key
+// already evaluated.
+type target struct {
+	e        Expr
+	obj, key interface{}
+}
+
+func (L *state) evalTarget(e Expr, sc *scope) target {
+	switch e := e.(type) {
+	case *NameExpr:
+	case *IndexExpr:
+		return target{e: e, obj: L.eval(e.Obj, sc), key: L.eval(e.Key, sc)}
+	default:
+		die("cannot assign to expression")
+	}
+	return target{e: e}
+}
+
+func (L *state) assign(t target, v interface{}, sc *scope) {
+	switch e := t.e.(type) {
+	case *NameExpr:
+		if p := sc.lookup(e.Name); p != nil {
+			*p = v
+		} else {
+			L.globals[e.Name] = v
+		}
+	case *IndexExpr:
+		L.curPos = e.Pos
+		L.checkIndex(t.obj, "__newindex", e.Obj, sc)
+		L.opSetIndex(t.obj, t.key, v)
+	}
+}
+
+// eval returns the first value of e.
+func (L *state) eval(e Expr, sc *scope) interface{} {
+	switch e := e.(type) {
+	case *ConstExpr:
+		return e.Value
+	case *NameExpr:
+		if p := sc.lookup(e.Name); p != nil {
+			return *p
+		}
+		return L.globals[e.Name]
+	case *ParenExpr:
+		return L.eval(e.Expr, sc)
+	case *VarargExpr:
+		if len(sc.fr.varargs) > 0 {
+			return sc.fr.varargs[0]
+		}
+		return nil
+	case *FuncExpr:
+		return &luaClosure{fn: e, env: sc}
+	case *IndexExpr:
+		obj, key := L.eval(e.Obj, sc), L.eval(e.Key, sc)
+		L.curPos = e.Pos
+		L.checkIndex(obj, "__index", e.Obj, sc)
+		return L.opIndex(obj, key)
+	case *TableExpr:
+		return L.evalTable(e, sc)
+	case *CallExpr:
+		if rs := L.evalCall(e, sc); len(rs) > 0 {
+			return rs[0]
+		}
+		return nil
+	case *UnOpExpr:
+		a := L.eval(e.Expr, sc)
+		L.curPos = e.Pos
+		defer func() {
+			if r := recover(); r != nil {
+				panic(nameOperand(r, varInfo(e.Expr, sc), ""))
+			}
+		}()
+		return L.unOp(e.Op, a)
+	case *BinOpExpr:
+		a := L.eval(e.Lhs, sc)
+		// the right side of and/or is only evaluated when needed
+		rhs := func() interface{} { return L.eval(e.Rhs, sc) }
+		switch e.Op {
+		case AND:
+			return opAnd(a, rhs)
+		case OR:
+			return opOr(a, rhs)
+		}
+		b := rhs()
+		L.curPos = e.Pos
+		defer func() {
+			if r := recover(); r != nil {
+				panic(nameOperand(r, varInfo(e.Lhs, sc), varInfo(e.Rhs, sc)))
+			}
+		}()
+		return L.arith(e.Op, a, b)
+	}
+	panic("unknown expression")
+}
+
+// evalList evaluates a list of expressions, the last one may expand to
+// multiple values.
+func (L *state) evalList(es []Expr, sc *scope) []interface{} {
+	vs := make([]interface{}, 0, len(es))
+	for i, e := range es {
+		if i == len(es)-1 {
+			return append(vs, L.evalMulti(e, sc)...)
+		}
+		vs = append(vs, L.eval(e, sc))
+	}
+	return vs
+}
+
+// evalMulti returns all the values of e, only calls and `...` can have
+// more than one.
+func (L *state) evalMulti(e Expr, sc *scope) []interface{} {
+	switch e := e.(type) {
+	case *CallExpr:
+		return L.evalCall(e, sc)
+	case *VarargExpr:
+		return sc.fr.varargs
+	}
+	return []interface{}{L.eval(e, sc)}
+}
+
+func (L *state) evalTable(e *TableExpr, sc *scope) *luaTable {
+	var items []interface{}
+	nhash := 0
+	for _, f := range e.Fields {
+		if f.Key != nil {
+			nhash++
+		}
+	}
+	t := newTable(0, nhash)
+	for i, f := range e.Fields {
+		if f.Key == nil {
+			if i == len(e.Fields)-1 {
+				items = append(items, L.evalMulti(f.Value, sc)...)
+			} else {
+				items = append(items, L.eval(f.Value, sc))
+			}
+			continue
+		}
+		k, v := L.eval(f.Key, sc), L.eval(f.Value, sc)
+		L.curPos = e.Pos
+		t.set(k, v)
+	}
+	if len(items) > 0 {
+		// positional items win over explicit keys, as in `{[1] = 0, 1}`
+		for i := range items {
+			t.hashSet(int64(i+1), nil)
+		}
+		t.arr = items
+		t.migrate()
+	}
+	return t
+}
+
+// checkIndex raises an error if v, the value of e, cannot be indexed.
+func (L *state) checkIndex(v interface{}, event string, e Expr, sc *scope) {
+	if _, ok := v.(*luaTable); !ok && L.metaOf(v, event) == nil {
+		die("attempt to index a %s value%s", valType(v), varInfo(e, sc))
+	}
+}
+
+// varInfo describes the variable e for error messages.
+func varInfo(e Expr, sc *scope) string {
+	switch e := e.(type) {
+	case *NameExpr:
+		return fmt.Sprintf(" (%s '%s')", sc.kind(e.Name), e.Name)
+	case *IndexExpr:
+		if k, ok := e.Key.(*ConstExpr); ok {
+			if s, ok := k.Value.(string); ok {
+				return fmt.Sprintf(" (field '%s')", s)
+			}
+		}
+	}
+	return ""
+}
+
+func (L *state) evalCall(e *CallExpr, sc *scope) []interface{} {
+	if e.Method != "" {
+		// obj:m(args) is obj.m(obj, args) with obj evaluated once
+		obj := L.eval(e.Func, sc)
+		L.curPos = e.Pos
+		L.checkIndex(obj, "__index", e.Func, sc)
+		fn := L.opIndex(obj, e.Method)
+		args := append([]interface{}{obj}, L.evalList(e.Args, sc)...)
+		L.curPos = e.Pos
+		if !callable(fn) && L.metaOf(fn, "__call") == nil {
+			die("attempt to call a %s value (method '%s')", valType(fn), e.Method)
+		}
+		return L.call(fn, args...)
+	}
+	fn := L.eval(e.Func, sc)
+	args := L.evalList(e.Args, sc)
+	L.curPos = e.Pos
+	if !callable(fn) && L.metaOf(fn, "__call") == nil {
+		die("attempt to call a %s value%s", valType(fn), varInfo(e.Func, sc))
+	}
+	return L.call(fn, args...)
+}
+
+// callable reports whether fn is a function, __call is not considered.
+func callable(fn interface{}) bool {
+	switch fn.(type) {
+	case *luaFunc, *luaClosure:
+		return true
+	}
+	return false
+}
+
+// call calls fn and returns all its results.
+func (L *state) call(fn interface{}, args ...interface{}) []interface{} {
+	switch fn.(type) {
+	case *luaFunc, *luaClosure:
+	default:
+		if h := L.metaOf(fn, "__call"); h != nil {
+			return L.call(h, append([]interface{}{fn}, args...)...)
+		}
+		die("attempt to call a %s value", valType(fn))
+	}
+	if len(L.callStack) >= maxCallDepth {
+		die("stack overflow")
+	}
+	L.callStack = append(L.callStack, callInfo{fn, L.curPos, L.curChunk})
+	var rs []interface{}
+	switch fn := fn.(type) {
+	case *luaFunc:
+		rs = (*fn)(L, args...)
+	case *luaClosure:
+		rs = L.callClosure(fn, args)
+	}
+	// back in the caller, errors are reported where the call was made
+	ci := L.callStack[len(L.callStack)-1]
+	L.popCalls(len(L.callStack) - 1)
+	L.curPos, L.curChunk = ci.pos, ci.chunk
+	return rs
+}
+
+// popCalls drops the calls above the first n, their functions are cleared
+// so that they can be collected.
+func (L *state) popCalls(n int) {
+	for i := n; i < len(L.callStack); i++ {
+		L.callStack[i] = callInfo{}
+	}
+	L.callStack = L.callStack[:n]
+}
+
+// maxCallDepth limits the nesting of calls before the Go stack runs out.
+const maxCallDepth = 200000
+
+func (L *state) callClosure(c *luaClosure, args []interface{}) []interface{} {
+	if c.p != nil {
+		return L.execute(c, args)
+	}
+	L.curChunk = c.fn.Chunk
+	var varargs []interface{}
+	if c.fn.Vararg && len(args) > len(c.fn.Params) {
+		varargs = args[len(c.fn.Params):]
+	}
+	sc := newCallScope(c.env, varargs)
+	for i, name := range c.fn.Params {
+		var v interface{}
+		if i < len(args) {
+			v = args[i]
+		}
+		sc.define(name, v)
+	}
+	if c, _ := L.execStats(c.fn.Block, sc); c == ctrlReturn {
+		return sc.fr.ret
+	}
+	return nil
+}
+
+func (L *state) unOp(op int, a interface{}) interface{} {
+	switch op {
+	case NOT:
+		return opNot(a)
+	case '-':
+		return L.opNegative(a)
+	case '#':
+		return L.opLen(a)
+	case '~':
+		return L.opBnot(a)
+	}
+	panic("unknown operator")
+}
+
+func (L *state) arith(op int, a, b interface{}) interface{} {
+	switch op {
+	case '^':
+		return L.opPow(a, b)
+	case '*':
+		return L.opMultiply(a, b)
+	case '/':
+		return L.opDevide(a, b)
+	case '%':
+		return L.opMod(a, b)
+	case IDIV:
+		return L.opIdiv(a, b)
+	case '+':
+		return L.opAdd(a, b)
+	case '-':
+		return L.opMinus(a, b)
+	case StrAppend:
+		return L.opStrAppend(a, b)
+	case SHL:
+		return L.opShl(a, b)
+	case SHR:
+		return L.opShr(a, b)
+	case '&':
+		return L.opBand(a, b)
+	case '~':
+		return L.opBxor(a, b)
+	case '|':
+		return L.opBor(a, b)
+	case LT:
+		return L.opLT(a, b)
+	case LE:
+		return L.opLE(a, b)
+	case GT:
+		return L.opGT(a, b)
+	case GE:
+		return L.opGE(a, b)
+	case EQ:
+		return L.opEQ(a, b)
+	case NE:
+		return L.opNE(a, b)
+	}
+	panic("unknown operator")
+}
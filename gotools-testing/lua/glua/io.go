@@ -0,0 +1,475 @@
+package glua
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// luaFile is an open file, scripts see it as a userdata with fileMeta as
+// its metatable. Reads are buffered, writes go straight to the file so
+// that they interleave with print.
+type luaFile struct {
+	f      *os.File
+	r      *bufio.Reader
+	mu     *sync.Mutex // guards r if it is shared
+	closed bool
+	std    bool // io.stdin, io.stdout or io.stderr
+}
+
+// stdin is the reader of the standard input of every State, so that one
+// does not lose what another read ahead. States run in parallel, they
+// take stdinMu to use it.
+var (
+	stdin   = bufio.NewReader(os.Stdin)
+	stdinMu sync.Mutex
+)
+
+// newFile returns the file f for scripts. Files that scripts drop without
+// closing them are closed when they are garbage collected.
+func (L *state) newFile(f *os.File, std bool) *luaUserdata {
+	file := &luaFile{f: f, std: std}
+	if f == os.Stdin {
+		file.r, file.mu = stdin, &stdinMu
+	}
+	if !std {
+		runtime.SetFinalizer(file, (*luaFile).close)
+	}
+	return &luaUserdata{file, L.fileMeta}
+}
+
+// toFile returns the file behind a, or nil if a is not a file.
+func toFile(a interface{}) *luaFile {
+	if u, ok := a.(*luaUserdata); ok {
+		f, _ := u.value.(*luaFile)
+		return f
+	}
+	return nil
+}
+
+// checkFile returns the n-th argument of the builtin fname, which must be
+// an open file.
+func checkFile(args []interface{}, n int, fname string) *luaFile {
+	f := toFile(argAt(args, n-1))
+	if f == nil {
+		die("bad argument #%d to '%s' (FILE* expected, got %s)", n, fname, argType(args, n-1))
+	}
+	if f.closed {
+		die("attempt to use a closed file")
+	}
+	return f
+}
+
+// defaultFile returns the default input or output file.
+func defaultFile(u *luaUserdata, what string) *luaFile {
+	f := u.value.(*luaFile)
+	if f.closed {
+		die("standard %s file is closed", what)
+	}
+	return f
+}
+
+// fileResult is what the io functions return on failure: nil, the
+// message and the error number. name prefixes the message if it is set.
+func fileResult(err error, name string) []interface{} {
+	msg := err.Error()
+	code := int64(0)
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		// C capitalizes the messages of strerror
+		msg = errno.Error()
+		msg = strings.ToUpper(msg[:1]) + msg[1:]
+		code = int64(errno)
+	}
+	if name != "" {
+		msg = name + ": " + msg
+	}
+	return []interface{}{nil, msg, code}
+}
+
+// openFlags converts a mode of io.open to the flags of os.OpenFile.
+func openFlags(mode string) (int, bool) {
+	if mode == "" {
+		return 0, false
+	}
+	rest := mode[1:]
+	plus := strings.HasPrefix(rest, "+")
+	if plus {
+		rest = rest[1:]
+	}
+	if strings.Trim(rest, "b") != "" {
+		return 0, false
+	}
+	var flag int
+	switch mode[0] {
+	case 'r':
+		flag = os.O_RDONLY
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return 0, false
+	}
+	if plus {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+	return flag, true
+}
+
+func (f *luaFile) reader() *bufio.Reader {
+	if f.r == nil {
+		f.r = bufio.NewReader(f.f)
+	}
+	return f.r
+}
+
+// sync moves the file offset back over the bytes that were read ahead,
+// it runs before the file is written or sought.
+func (f *luaFile) sync() error {
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	if f.r == nil || f.r.Buffered() == 0 {
+		return nil
+	}
+	if _, err := f.f.Seek(-int64(f.r.Buffered()), io.SeekCurrent); err != nil {
+		return err
+	}
+	f.r.Reset(f.f)
+	return nil
+}
+
+// read reads the formats args[n-1:] of the builtin fname, it stops at the
+// first one that fails.
+func (f *luaFile) read(args []interface{}, n int, fname string) []interface{} {
+	if f.mu != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+	}
+	r := f.reader()
+	if len(args) < n {
+		return []interface{}{readLine(r, false)}
+	}
+	var rs []interface{}
+	for i := n; i <= len(args); i++ {
+		var v interface{}
+		if isNumber(args[i-1]) {
+			v = readChars(r, checkInteger(args, i, fname))
+		} else {
+			p := strings.TrimPrefix(checkString(args, i, fname), "*")
+			if p == "" {
+				p = "?"
+			}
+			switch p[0] {
+			case 'n':
+				v = readNumber(r)
+			case 'l':
+				v = readLine(r, false)
+			case 'L':
+				v = readLine(r, true)
+			case 'a':
+				b, _ := io.ReadAll(r)
+				v = string(b)
+			default:
+				die("bad argument #%d to '%s' (invalid format)", i, fname)
+			}
+		}
+		rs = append(rs, v)
+		if v == nil {
+			break
+		}
+	}
+	return rs
+}
+
+// readLine reads the next line, keep keeps its end of line.
+func readLine(r *bufio.Reader, keep bool) interface{} {
+	s, err := r.ReadString('\n')
+	if err != nil && s == "" {
+		return nil
+	}
+	if !keep {
+		s = strings.TrimSuffix(s, "\n")
+	}
+	return s
+}
+
+// readChars reads up to n bytes, n == 0 tests for the end of the file.
+func readChars(r *bufio.Reader, n int64) interface{} {
+	if n <= 0 {
+		if _, err := r.Peek(1); err != nil {
+			return nil
+		}
+		return ""
+	}
+	var b strings.Builder
+	io.CopyN(&b, r, n)
+	if b.Len() == 0 {
+		return nil
+	}
+	return b.String()
+}
+
+// readNumber reads a numeral the way the reference implementation does:
+// it takes the longest prefix that looks like a number and converts it.
+func readNumber(r *bufio.Reader) interface{} {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil
+		}
+		if !strings.ContainsRune(" \t\n\v\f\r", rune(c)) {
+			r.UnreadByte()
+			break
+		}
+	}
+	var b []byte
+	accept := func(set string) bool {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if len(b) < 200 && strings.IndexByte(set, c) >= 0 {
+			b = append(b, c)
+			return true
+		}
+		r.UnreadByte()
+		return false
+	}
+	digits := func(hex bool) int {
+		set := "0123456789"
+		if hex {
+			set += "abcdefABCDEF"
+		}
+		n := 0
+		for accept(set) {
+			n++
+		}
+		return n
+	}
+	accept("+-")
+	count, hex := 0, false
+	if accept("0") {
+		count = 1
+		if accept("xX") {
+			count, hex = 0, true
+		}
+	}
+	count += digits(hex)
+	if accept(".") {
+		count += digits(hex)
+	}
+	exp := "eE"
+	if hex {
+		exp = "pP"
+	}
+	if count > 0 && accept(exp) {
+		accept("+-")
+		digits(false)
+	}
+	if n, ok := strToNumber(string(b)); ok {
+		return n
+	}
+	return nil
+}
+
+// write writes args[n-1:] of the builtin fname and returns file.
+func (f *luaFile) write(file interface{}, args []interface{}, n int, fname string) []interface{} {
+	f.sync()
+	for i := n; i <= len(args); i++ {
+		if _, err := f.f.WriteString(checkString(args, i, fname)); err != nil {
+			return fileResult(err, "")
+		}
+	}
+	return []interface{}{file}
+}
+
+func (f *luaFile) close() []interface{} {
+	if f.std {
+		return []interface{}{nil, "cannot close standard file"}
+	}
+	f.closed = true
+	if err := f.f.Close(); err != nil {
+		return fileResult(err, "")
+	}
+	return []interface{}{true}
+}
+
+// lines returns the iterator of io.lines and file:lines, toClose closes
+// the file at its end.
+func lines(file interface{}, formats []interface{}, toClose bool) *luaFunc {
+	f := toFile(file)
+	return newFunc(func(L *state, _ ...interface{}) []interface{} {
+		if f.closed {
+			die("file is already closed")
+		}
+		rs := f.read(formats, 1, "lines")
+		if rs[0] == nil && toClose {
+			f.close()
+		}
+		return rs
+	})
+}
+
+// openIO builds the io library and the metatable of files.
+func (L *state) openIO() *luaTable {
+	methods := map[string]luaFunc{
+		"read": func(L *state, args ...interface{}) []interface{} {
+			return checkFile(args, 1, "read").read(args, 2, "read")
+		},
+		"write": func(L *state, args ...interface{}) []interface{} {
+			return checkFile(args, 1, "write").write(args[0], args, 2, "write")
+		},
+		"lines": func(L *state, args ...interface{}) []interface{} {
+			checkFile(args, 1, "lines")
+			return []interface{}{lines(args[0], args[1:], false)}
+		},
+		"seek": func(L *state, args ...interface{}) []interface{} {
+			f := checkFile(args, 1, "seek")
+			whence := map[string]int{"set": io.SeekStart, "cur": io.SeekCurrent, "end": io.SeekEnd}
+			w := "cur"
+			if argAt(args, 1) != nil {
+				w = checkString(args, 2, "seek")
+			}
+			if _, ok := whence[w]; !ok {
+				die("bad argument #2 to 'seek' (invalid option '%s')", w)
+			}
+			off := optInteger(args, 3, "seek", 0)
+			if err := f.sync(); err != nil {
+				return fileResult(err, "")
+			}
+			pos, err := f.f.Seek(off, whence[w])
+			if err != nil {
+				return fileResult(err, "")
+			}
+			return []interface{}{pos}
+		},
+		"close": func(L *state, args ...interface{}) []interface{} {
+			return checkFile(args, 1, "close").close()
+		},
+		"flush": func(L *state, args ...interface{}) []interface{} {
+			checkFile(args, 1, "flush")
+			return []interface{}{args[0]}
+		},
+		"setvbuf": func(L *state, args ...interface{}) []interface{} {
+			checkFile(args, 1, "setvbuf")
+			return []interface{}{true}
+		},
+	}
+	m := newTable(0, len(methods))
+	for name, fn := range methods {
+		m.set(name, newFunc(fn))
+	}
+	L.fileMeta = newTable(0, 3)
+	L.fileMeta.set("__index", m)
+	L.fileMeta.set("__name", "FILE*")
+	L.fileMeta.set("__tostring", newFunc(func(L *state, args ...interface{}) []interface{} {
+		if f := toFile(argAt(args, 0)); f != nil && f.closed {
+			return []interface{}{"file (closed)"}
+		}
+		return []interface{}{fmt.Sprintf("file (%p)", argAt(args, 0))}
+	}))
+
+	stdin := L.newFile(os.Stdin, true)
+	stdout := L.newFile(os.Stdout, true)
+	L.defaultInput, L.defaultOutput = stdin, stdout
+
+	// setDefault implements io.input and io.output
+	setDefault := func(args []interface{}, def **luaUserdata, mode, fname string) []interface{} {
+		switch a := argAt(args, 0).(type) {
+		case nil:
+		case string:
+			flag, _ := openFlags(mode)
+			fh, err := os.OpenFile(a, flag, 0666)
+			if err != nil {
+				die("%s", fileResult(err, a)[1])
+			}
+			*def = L.newFile(fh, false)
+		default:
+			checkFile(args, 1, fname)
+			*def = a.(*luaUserdata)
+		}
+		return []interface{}{*def}
+	}
+
+	lib := map[string]luaFunc{
+		"open": func(L *state, args ...interface{}) []interface{} {
+			name := checkString(args, 1, "open")
+			mode := "r"
+			if argAt(args, 1) != nil {
+				mode = checkString(args, 2, "open")
+			}
+			flag, ok := openFlags(mode)
+			if !ok {
+				die("bad argument #2 to 'open' (invalid mode)")
+			}
+			fh, err := os.OpenFile(name, flag, 0666)
+			if err != nil {
+				return fileResult(err, name)
+			}
+			return []interface{}{L.newFile(fh, false)}
+		},
+		"close": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				return defaultFile(L.defaultOutput, "output").close()
+			}
+			return checkFile(args, 1, "close").close()
+		},
+		"read": func(L *state, args ...interface{}) []interface{} {
+			return defaultFile(L.defaultInput, "input").read(args, 1, "read")
+		},
+		"write": func(L *state, args ...interface{}) []interface{} {
+			return defaultFile(L.defaultOutput, "output").write(L.defaultOutput, args, 1, "write")
+		},
+		"lines": func(L *state, args ...interface{}) []interface{} {
+			var formats []interface{}
+			if len(args) > 1 {
+				formats = args[1:]
+			}
+			if argAt(args, 0) == nil {
+				defaultFile(L.defaultInput, "input")
+				return []interface{}{lines(L.defaultInput, formats, false)}
+			}
+			name := checkString(args, 1, "lines")
+			fh, err := os.Open(name)
+			if err != nil {
+				die("%s", fileResult(err, name)[1])
+			}
+			return []interface{}{lines(L.newFile(fh, false), formats, true)}
+		},
+		"input": func(L *state, args ...interface{}) []interface{} {
+			return setDefault(args, &L.defaultInput, "r", "input")
+		},
+		"output": func(L *state, args ...interface{}) []interface{} {
+			return setDefault(args, &L.defaultOutput, "w", "output")
+		},
+		"type": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				die("bad argument #1 to 'type' (value expected)")
+			}
+			switch f := toFile(args[0]); {
+			case f == nil:
+				return []interface{}{nil}
+			case f.closed:
+				return []interface{}{"closed file"}
+			}
+			return []interface{}{"file"}
+		},
+	}
+	t := newTable(0, len(lib)+3)
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	t.set("stdin", stdin)
+	t.set("stdout", stdout)
+	t.set("stderr", L.newFile(os.Stderr, true))
+	return t
+}
@@ -0,0 +1,47 @@
+package glua
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// openFiles returns the number of open file descriptors of the process.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count the open files:", err)
+	}
+	return len(fds)
+}
+
+func TestDroppedFilesAreClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := openFiles(t)
+	L := NewState()
+	L.SetGlobal("path", path)
+	if err := L.DoString(`
+		for i = 1, 100 do
+			for l in io.lines(path) do break end
+			local f = io.open(path)
+			f:read()
+		end
+	`); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for openFiles(t) > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files open, want at most %d", openFiles(t), before)
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	runtime.KeepAlive(L)
+}
@@ -0,0 +1,506 @@
+%{
+package glua
+
+import ("fmt";"io";"os")
+%}
+
+%union {
+    pos  Pos
+    n    interface{} // int64 or float64
+    b    bool
+    s    string
+
+    stat  Stat
+    block *Block
+    expr  Expr
+    exprs []Expr
+    names []string
+    fields []Field
+}
+
+%token AND
+%token OR
+%token NOT
+
+%token LT
+%token LE
+%token GT
+%token GE
+%token EQ
+%token NE
+
+%token StrAppend
+%token IDIV
+%token SHL
+%token SHR
+
+%token IF
+%token THEN
+%token ELIF
+%token ELSE
+
+%token DO
+%token END
+%token WHILE
+%token FOR
+%token REPEAT
+%token UNTIL
+%token BREAK
+
+%token FUNC
+%token RET
+
+%token IN
+%token LOCAL
+
+%token NIL
+%token BOOL
+
+%token STR
+%token NUM
+%token VAL
+
+%token DOTS
+
+%%
+chunk: prog
+    | prog retstat {
+        yylex.(*luaLexer).addStat($2.stat)
+    };
+
+prog: {
+        // println("Y prog | <empty>")
+    } | prog stat {
+        // println("Y prog | prog stat")
+        yylex.(*luaLexer).addStat($2.stat)
+    };
+
+stat: expr {
+        $$.stat = &ExprStat{Pos: $1.pos, Expr: $1.expr}
+    } | LOCAL namelist {
+        $$.stat = &LocalStat{Pos: $1.pos, Names: $2.names}
+    } | LOCAL namelist '=' exprlist {
+        $$.stat = &LocalStat{Pos: $1.pos, Names: $2.names, Exprs: $4.exprs}
+    } | varlist '=' exprlist {
+        $$.stat = &AssignStat{Pos: $2.pos, Lhs: $1.exprs, Rhs: $3.exprs}
+    } | ';' {
+        // println("Y stat | ;")
+        $$.stat = nil
+    } | DO block END {
+        $2.block.Pos = $1.pos
+        $$.stat = &DoStat{Pos: $1.pos, Block: $2.block}
+    } | while expr DO block END {
+        yylex.(*luaLexer).fs.loop--
+        $4.block.Pos = $3.pos
+        $$.stat = &WhileStat{Pos: $1.pos, Cond: $2.expr, Block: $4.block}
+    } | repeat block UNTIL expr {
+        yylex.(*luaLexer).fs.loop--
+        $2.block.Pos = $1.pos
+        $$.stat = &RepeatStat{Pos: $1.pos, Block: $2.block, Cond: $4.expr}
+    } | for VAL '=' expr ',' expr DO block END {
+        yylex.(*luaLexer).fs.loop--
+        $8.block.Pos = $7.pos
+        $$.stat = &NumForStat{
+            Pos:   $1.pos,
+            Name:  $2.s,
+            Start: $4.expr,
+            Limit: $6.expr,
+            Block: $8.block,
+        }
+    } | for VAL '=' expr ',' expr ',' expr DO block END {
+        yylex.(*luaLexer).fs.loop--
+        $10.block.Pos = $9.pos
+        $$.stat = &NumForStat{
+            Pos:   $1.pos,
+            Name:  $2.s,
+            Start: $4.expr,
+            Limit: $6.expr,
+            Step:  $8.expr,
+            Block: $10.block,
+        }
+    } | for namelist IN exprlist DO block END {
+        yylex.(*luaLexer).fs.loop--
+        $6.block.Pos = $5.pos
+        $$.stat = &GenForStat{
+            Pos:   $1.pos,
+            Names: $2.names,
+            Exprs: $4.exprs,
+            Block: $6.block,
+        }
+    } | BREAK {
+        if yylex.(*luaLexer).fs.loop == 0 {
+            yylex.Error(fmt.Sprintf("<break> at line %d not inside a loop", $1.pos.Line))
+        }
+        $$.stat = &BreakStat{Pos: $1.pos}
+    } | FUNC funcname funcbody {
+        $3.expr.(*FuncExpr).Name = $2.s
+        $$.stat = &AssignStat{
+            Pos: $1.pos,
+            Lhs: []Expr{$2.expr},
+            Rhs: []Expr{$3.expr},
+        }
+    } | FUNC funcname ':' VAL funcbody {
+        f := $5.expr.(*FuncExpr)
+        f.Name = $2.s + ":" + $4.s
+        f.Params = append([]string{"self"}, f.Params...)
+        $$.stat = &AssignStat{
+            Pos: $1.pos,
+            Lhs: []Expr{&IndexExpr{
+                Pos: $3.pos,
+                Obj: $2.expr,
+                Key: &ConstExpr{Pos: $4.pos, Value: $4.s},
+            }},
+            Rhs: []Expr{f},
+        }
+    } | LOCAL FUNC VAL funcbody {
+        $4.expr.(*FuncExpr).Name = $3.s
+        $$.stat = &LocalFuncStat{Pos: $1.pos, Name: $3.s, Func: $4.expr.(*FuncExpr)}
+    } | ifthen END {
+        $$.stat = $1.stat
+    } | ifthen ELSE block END {
+        s := $1.stat.(*IfStat)
+        s.Else = $3.block
+        s.Else.Pos = $2.pos
+        $$.stat = s
+    };
+
+while: WHILE {
+        yylex.(*luaLexer).fs.loop++
+    };
+
+for: FOR {
+        yylex.(*luaLexer).fs.loop++
+    };
+
+repeat: REPEAT {
+        yylex.(*luaLexer).fs.loop++
+    };
+
+ifthen: IF expr THEN block {
+        $4.block.Pos = $3.pos
+        $$.stat = &IfStat{
+            Pos:    $1.pos,
+            Conds:  []Expr{$2.expr},
+            Blocks: []*Block{$4.block},
+        }
+    } | ifthen ELIF expr THEN block {
+        s := $1.stat.(*IfStat)
+        $5.block.Pos = $4.pos
+        s.Conds = append(s.Conds, $3.expr)
+        s.Blocks = append(s.Blocks, $5.block)
+        $$.stat = s
+    };
+
+block: stats
+    | stats retstat {
+        $1.block.Stats = append($1.block.Stats, $2.stat)
+        $$.block = $1.block
+    };
+
+stats: {
+        $$.block = &Block{}
+    } | stats stat {
+        if $2.stat != nil {
+            $1.block.Stats = append($1.block.Stats, $2.stat)
+        }
+        $$.block = $1.block
+    };
+
+retstat: RET {
+        $$.stat = &ReturnStat{Pos: $1.pos}
+    } | RET ';' {
+        $$.stat = &ReturnStat{Pos: $1.pos}
+    } | RET exprlist {
+        $$.stat = &ReturnStat{Pos: $1.pos, Exprs: $2.exprs}
+    } | RET exprlist ';' {
+        $$.stat = &ReturnStat{Pos: $1.pos, Exprs: $2.exprs}
+    };
+
+funcname: VAL {
+        $$.expr = &NameExpr{Pos: $1.pos, Name: $1.s}
+    } | funcname '.' VAL {
+        $$.expr = &IndexExpr{
+            Pos: $2.pos,
+            Obj: $1.expr,
+            Key: &ConstExpr{Pos: $3.pos, Value: $3.s},
+        }
+        $$.s = $1.s + "." + $3.s
+    };
+
+funcbody: params block END {
+        l := yylex.(*luaLexer)
+        $2.block.Pos = $1.pos
+        $$.expr = &FuncExpr{
+            Pos:    $1.pos,
+            Chunk:  l.name,
+            Params: $1.names,
+            Vararg: l.fs.vararg,
+            Block:  $2.block,
+        }
+        l.fs = l.fs.parent
+    };
+
+params: '(' parlist ')' {
+        l := yylex.(*luaLexer)
+        l.fs = &funcState{parent: l.fs, vararg: $2.b}
+        $$.names = $2.names
+        $$.pos = $1.pos
+    };
+
+parlist: {
+        $$.names = nil
+        $$.b = false
+    } | namelist {
+        $$.b = false
+    } | namelist ',' DOTS {
+        $$.b = true
+    } | DOTS {
+        $$.names = nil
+        $$.b = true
+    };
+
+/* Binary operators from the lowest to the highest precedence, all left
+   associative except '..' and '^'. Unary operators bind tighter than
+   everything but '^', so -2^2 is -(2^2) while 2^-2 is 2^(-2). */
+
+expr: expr11
+    | expr OR expr11 {
+        $$.expr = binOp($2.pos, OR, $1.expr, $3.expr)
+    };
+
+expr11: expr10
+    | expr11 AND expr10 {
+        $$.expr = binOp($2.pos, AND, $1.expr, $3.expr)
+    };
+
+expr10: expr9
+    | expr10 LT expr9 {
+        $$.expr = binOp($2.pos, LT, $1.expr, $3.expr)
+    } | expr10 LE expr9 {
+        $$.expr = binOp($2.pos, LE, $1.expr, $3.expr)
+    } | expr10 GT expr9 {
+        $$.expr = binOp($2.pos, GT, $1.expr, $3.expr)
+    } | expr10 GE expr9 {
+        $$.expr = binOp($2.pos, GE, $1.expr, $3.expr)
+    } | expr10 EQ expr9 {
+        $$.expr = binOp($2.pos, EQ, $1.expr, $3.expr)
+    } | expr10 NE expr9 {
+        $$.expr = binOp($2.pos, NE, $1.expr, $3.expr)
+    };
+
+expr9: expr8
+    | expr9 '|' expr8 {
+        $$.expr = binOp($2.pos, '|', $1.expr, $3.expr)
+    };
+
+expr8: expr7
+    | expr8 '~' expr7 {
+        $$.expr = binOp($2.pos, '~', $1.expr, $3.expr)
+    };
+
+expr7: expr6
+    | expr7 '&' expr6 {
+        $$.expr = binOp($2.pos, '&', $1.expr, $3.expr)
+    };
+
+expr6: expr5
+    | expr6 SHL expr5 {
+        $$.expr = binOp($2.pos, SHL, $1.expr, $3.expr)
+    } | expr6 SHR expr5 {
+        $$.expr = binOp($2.pos, SHR, $1.expr, $3.expr)
+    };
+
+expr5: expr4
+    | expr4 StrAppend expr5 {
+        $$.expr = binOp($2.pos, StrAppend, $1.expr, $3.expr)
+    };
+
+expr4: expr3
+    | expr4 '+' expr3 {
+        $$.expr = binOp($2.pos, '+', $1.expr, $3.expr)
+    } | expr4 '-' expr3 {
+        $$.expr = binOp($2.pos, '-', $1.expr, $3.expr)
+    };
+
+expr3: expr2
+    | expr3 '*' expr2 {
+        $$.expr = binOp($2.pos, '*', $1.expr, $3.expr)
+    } | expr3 '/' expr2 {
+        $$.expr = binOp($2.pos, '/', $1.expr, $3.expr)
+    } | expr3 '%' expr2 {
+        $$.expr = binOp($2.pos, '%', $1.expr, $3.expr)
+    } | expr3 IDIV expr2 {
+        $$.expr = binOp($2.pos, IDIV, $1.expr, $3.expr)
+    };
+
+expr2: expr1
+    | NOT expr2 {
+        $$.expr = &UnOpExpr{Pos: $1.pos, Op: NOT, Expr: $2.expr}
+    } | '-' expr2 {
+        $$.expr = &UnOpExpr{Pos: $1.pos, Op: '-', Expr: $2.expr}
+    } | '#' expr2 {
+        $$.expr = &UnOpExpr{Pos: $1.pos, Op: '#', Expr: $2.expr}
+    } | '~' expr2 {
+        $$.expr = &UnOpExpr{Pos: $1.pos, Op: '~', Expr: $2.expr}
+    };
+
+expr1: expr0
+    | expr0 '^' expr2 {
+        $$.expr = binOp($2.pos, '^', $1.expr, $3.expr)
+    };
+
+expr0: data
+    | prefixexp;
+
+data: NIL {
+        $$.expr = &ConstExpr{Pos: $1.pos, Value: nil}
+    } | BOOL {
+        $$.expr = &ConstExpr{Pos: $1.pos, Value: $1.b}
+    } | STR {
+        $$.expr = &ConstExpr{Pos: $1.pos, Value: $1.s}
+    } | NUM {
+        $$.expr = &ConstExpr{Pos: $1.pos, Value: $1.n}
+    } | DOTS {
+        if !yylex.(*luaLexer).fs.vararg {
+            yylex.Error("cannot use '...' outside a vararg function")
+        }
+        $$.expr = &VarargExpr{Pos: $1.pos}
+    } | FUNC funcbody {
+        $2.expr.(*FuncExpr).Pos = $1.pos
+        $$.expr = $2.expr
+    } | table;
+
+prefixexp: var
+    | call
+    | '(' expr ')' {
+        $$.expr = &ParenExpr{Pos: $1.pos, Expr: $2.expr}
+    };
+
+var: VAL {
+        $$.expr = &NameExpr{Pos: $1.pos, Name: $1.s}
+    } | prefixexp '[' expr ']' {
+        $$.expr = &IndexExpr{Pos: $2.pos, Obj: $1.expr, Key: $3.expr}
+    } | prefixexp '.' VAL {
+        $$.expr = &IndexExpr{
+            Pos: $2.pos,
+            Obj: $1.expr,
+            Key: &ConstExpr{Pos: $3.pos, Value: $3.s},
+        }
+    };
+
+call: prefixexp args {
+        $$.expr = &CallExpr{Pos: $2.pos, Func: $1.expr, Args: $2.exprs}
+    } | prefixexp ':' VAL args {
+        $$.expr = &CallExpr{Pos: $2.pos, Func: $1.expr, Method: $3.s, Args: $4.exprs}
+    };
+
+args: '(' ')' {
+        $$.exprs = nil
+    } | '(' exprlist ')' {
+        $$.exprs = $2.exprs
+        $$.pos = $1.pos
+    } | table {
+        $$.exprs = []Expr{$1.expr}
+    } | STR {
+        $$.exprs = []Expr{&ConstExpr{Pos: $1.pos, Value: $1.s}}
+    };
+
+table: '{' '}' {
+        $$.expr = &TableExpr{Pos: $1.pos}
+    } | '{' fields '}' {
+        $$.expr = &TableExpr{Pos: $1.pos, Fields: $2.fields}
+    } | '{' fields fieldsep '}' {
+        $$.expr = &TableExpr{Pos: $1.pos, Fields: $2.fields}
+    };
+
+fields: field
+    | fields fieldsep field {
+        $$.fields = append($1.fields, $3.fields...)
+    };
+
+fieldsep: ',' | ';';
+
+field: expr {
+        $$.fields = []Field{{Value: $1.expr}}
+    } | VAL '=' expr {
+        $$.fields = []Field{{Key: &ConstExpr{Pos: $1.pos, Value: $1.s}, Value: $3.expr}}
+    } | '[' expr ']' '=' expr {
+        $$.fields = []Field{{Key: $2.expr, Value: $5.expr}}
+    };
+
+varlist: var {
+        $$.exprs = []Expr{$1.expr}
+    } | varlist ',' var {
+        $$.exprs = append($1.exprs, $3.expr)
+    };
+
+namelist: VAL {
+        $$.names = []string{$1.s}
+    } | namelist ',' VAL {
+        $$.names = append($1.names, $3.s)
+    };
+
+exprlist: expr {
+        $$.exprs = []Expr{$1.expr}
+    } | exprlist ',' expr {
+        $$.exprs = append($1.exprs, $3.expr)
+    };
+%%
+
+func emit(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stdout,format, a...);
+	fmt.Fprintln(os.Stdout,"")
+}
+func die(format string, a ...interface{}) {
+    panic(fmt.Sprintf(format, a...))
+}
+
+func binOp(pos Pos, op int, lhs, rhs Expr) Expr {
+    return &BinOpExpr{Pos: pos, Op: op, Lhs: lhs, Rhs: rhs}
+}
+
+// luaLexer wraps the generated Lexer, records the position of every token
+// and collects the statements of the chunk.
+type luaLexer struct {
+    *Lexer
+    // name is the chunk name used in error messages.
+    name  string
+    chunk *Block
+    fs    *funcState
+    // exec runs top-level statements as soon as they are parsed (REPL).
+    exec func(Stat)
+}
+
+func newLuaLexer(r io.Reader, name string) *luaLexer {
+    return &luaLexer{
+        Lexer: NewLexer(r),
+        name:  name,
+        chunk: &Block{Pos: Pos{1, 1}},
+        fs:    &funcState{vararg: true},
+    }
+}
+
+// funcState is what the parser knows about the function being parsed.
+type funcState struct {
+    parent *funcState
+    vararg bool
+    // loop is the depth of loops around the token being parsed.
+    loop int
+}
+
+func (l *luaLexer) Lex(lval *yySymType) int {
+    t := l.Lexer.Lex(lval)
+    lval.pos = Pos{l.Line() + 1, l.Column() + 1}
+    return t
+}
+
+func (l *luaLexer) addStat(s Stat) {
+    if s == nil {
+        return
+    }
+    if l.exec != nil {
+        l.exec(s)
+        return
+    }
+    l.chunk.Stats = append(l.chunk.Stats, s)
+}
@@ -0,0 +1,196 @@
+package glua
+
+import (
+	"math"
+)
+
+// openMath builds the math library.
+func (L *state) openMath() *luaTable {
+	lib := map[string]luaFunc{
+		"type": func(L *state, args ...interface{}) []interface{} {
+			if len(args) == 0 {
+				die("bad argument #1 to 'type' (value expected)")
+			}
+			switch args[0].(type) {
+			case int64:
+				return []interface{}{"integer"}
+			case float64:
+				return []interface{}{"float"}
+			}
+			return []interface{}{nil}
+		},
+		"tointeger": func(L *state, args ...interface{}) []interface{} {
+			if n, ok := toInteger(argAt(args, 0)); ok {
+				return []interface{}{n}
+			}
+			return []interface{}{nil}
+		},
+		"abs": func(L *state, args ...interface{}) []interface{} {
+			switch x := argNumber(args, 1, "abs").(type) {
+			case int64:
+				if x < 0 {
+					x = -x
+				}
+				return []interface{}{x}
+			case float64:
+				return []interface{}{math.Abs(x)}
+			}
+			return nil
+		},
+		"ceil": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{roundNumber(argNumber(args, 1, "ceil"), math.Ceil)}
+		},
+		"floor": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{roundNumber(argNumber(args, 1, "floor"), math.Floor)}
+		},
+		"sqrt": mathFunc("sqrt", math.Sqrt),
+		"sin":  mathFunc("sin", math.Sin),
+		"cos":  mathFunc("cos", math.Cos),
+		"tan":  mathFunc("tan", math.Tan),
+		"asin": mathFunc("asin", math.Asin),
+		"acos": mathFunc("acos", math.Acos),
+		"exp":  mathFunc("exp", math.Exp),
+		"atan": func(L *state, args ...interface{}) []interface{} {
+			y := checkNumber(args, 1, "atan")
+			x := 1.0
+			if argAt(args, 1) != nil {
+				x = checkNumber(args, 2, "atan")
+			}
+			return []interface{}{math.Atan2(y, x)}
+		},
+		"log": func(L *state, args ...interface{}) []interface{} {
+			x := checkNumber(args, 1, "log")
+			if argAt(args, 1) == nil {
+				return []interface{}{math.Log(x)}
+			}
+			switch b := checkNumber(args, 2, "log"); b {
+			case 2:
+				return []interface{}{math.Log2(x)}
+			case 10:
+				return []interface{}{math.Log10(x)}
+			default:
+				return []interface{}{math.Log(x) / math.Log(b)}
+			}
+		},
+		"fmod": func(L *state, args ...interface{}) []interface{} {
+			a, b := argNumber(args, 1, "fmod"), argNumber(args, 2, "fmod")
+			if x, y, ok := integers(a, b); ok {
+				switch y {
+				case 0:
+					die("bad argument #2 to 'fmod' (zero)")
+				case -1:
+					// avoid overflow with mininteger
+					return []interface{}{int64(0)}
+				}
+				// C semantics, the result has the sign of a
+				return []interface{}{x % y}
+			}
+			x, _ := toFloat(a)
+			y, _ := toFloat(b)
+			return []interface{}{math.Mod(x, y)}
+		},
+		"modf": func(L *state, args ...interface{}) []interface{} {
+			switch x := argNumber(args, 1, "modf").(type) {
+			case int64:
+				return []interface{}{x, 0.0}
+			case float64:
+				n := math.Trunc(x)
+				frac := 0.0
+				if n != x {
+					frac = x - n
+				}
+				return []interface{}{n, frac}
+			}
+			return nil
+		},
+		"min": func(L *state, args ...interface{}) []interface{} {
+			m := argNumber(args, 1, "min")
+			for i := 2; i <= len(args); i++ {
+				if x := argNumber(args, i, "min"); numLT(x, m) {
+					m = x
+				}
+			}
+			return []interface{}{m}
+		},
+		"max": func(L *state, args ...interface{}) []interface{} {
+			m := argNumber(args, 1, "max")
+			for i := 2; i <= len(args); i++ {
+				if x := argNumber(args, i, "max"); numLT(m, x) {
+					m = x
+				}
+			}
+			return []interface{}{m}
+		},
+		"ult": func(L *state, args ...interface{}) []interface{} {
+			a, b := checkInteger(args, 1, "ult"), checkInteger(args, 2, "ult")
+			return []interface{}{uint64(a) < uint64(b)}
+		},
+		"random": func(L *state, args ...interface{}) []interface{} {
+			var low, up int64
+			switch len(args) {
+			case 0:
+				return []interface{}{L.rng.Float64()}
+			case 1:
+				low, up = 1, checkInteger(args, 1, "random")
+			case 2:
+				low, up = checkInteger(args, 1, "random"), checkInteger(args, 2, "random")
+			default:
+				die("wrong number of arguments")
+			}
+			if low > up {
+				die("bad argument #%d to 'random' (interval is empty)", len(args))
+			}
+			if low < 0 && up > math.MaxInt64+low {
+				die("bad argument #1 to 'random' (interval too large)")
+			}
+			if up-low == math.MaxInt64 {
+				return []interface{}{low + int64(L.rng.Uint64()>>1)}
+			}
+			return []interface{}{low + L.rng.Int63n(up-low+1)}
+		},
+		"randomseed": func(L *state, args ...interface{}) []interface{} {
+			n := checkNumber(args, 1, "randomseed")
+			L.rng.Seed(int64(n))
+			return nil
+		},
+	}
+	t := newTable(0, len(lib)+4)
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	t.set("huge", math.Inf(1))
+	t.set("pi", math.Pi)
+	t.set("maxinteger", int64(math.MaxInt64))
+	t.set("mininteger", int64(math.MinInt64))
+	return t
+}
+
+// mathFunc wraps a float function of one argument.
+func mathFunc(fname string, fn func(float64) float64) luaFunc {
+	return func(L *state, args ...interface{}) []interface{} {
+		return []interface{}{fn(checkNumber(args, 1, fname))}
+	}
+}
+
+// argNumber returns the n-th argument of the builtin fname, which must be
+// a number or a numeric string, as an integer or a float.
+func argNumber(args []interface{}, n int, fname string) interface{} {
+	a := coerce(argAt(args, n-1))
+	if !isNumber(a) {
+		die("bad argument #%d to '%s' (number expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return a
+}
+
+// roundNumber rounds a with round, the result is an integer if it fits.
+func roundNumber(a interface{}, round func(float64) float64) interface{} {
+	f, ok := a.(float64)
+	if !ok {
+		return a
+	}
+	f = round(f)
+	if n, ok := floatToInteger(f); ok {
+		return n
+	}
+	return f
+}
@@ -0,0 +1,340 @@
+package glua
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Numbers are int64 (integers) or float64 (floats), as in Lua 5.3.
+
+// parseNumber converts a numeral to an integer or a float. Decimal
+// integers that overflow become floats, hexadecimal integers wrap around.
+func parseNumber(s string) (interface{}, bool) {
+	if strings.ContainsAny(s, "_nN") {
+		// reject digit separators, inf and nan, which ParseFloat accepts
+		return nil, false
+	}
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		if !strings.ContainsAny(s, ".pP") {
+			var n int64
+			for _, c := range s[2:] {
+				d, ok := hexDigit(c)
+				if !ok {
+					return nil, false
+				}
+				n = n<<4 | d
+			}
+			return n, true
+		}
+		if !strings.ContainsAny(s, "pP") {
+			s += "p0"
+		}
+		f, e := strconv.ParseFloat(s, 64)
+		return f, e == nil
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		if n, e := strconv.ParseInt(s, 10, 64); e == nil {
+			return n, true
+		}
+	}
+	f, e := strconv.ParseFloat(s, 64)
+	if e != nil && f == 0 {
+		return nil, false
+	}
+	return f, true
+}
+
+// strToNumber converts a string to a number the way tonumber does,
+// surrounding spaces and a sign are allowed.
+func strToNumber(s string) (interface{}, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return nil, false
+	}
+	n, ok := parseNumber(s)
+	if !ok {
+		return nil, false
+	}
+	if neg {
+		switch x := n.(type) {
+		case int64:
+			return -x, true
+		case float64:
+			return -x, true
+		}
+	}
+	return n, true
+}
+
+// strToInteger converts a string of digits in the given base, as in
+// tonumber("ff", 16).
+func strToInteger(s string, base int64) (int64, bool) {
+	s = strings.Trim(s, " \f\n\r\t\v")
+	neg := false
+	if s != "" && s[0] == '-' {
+		neg = true
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false
+	}
+	var n int64
+	for _, c := range strings.ToLower(s) {
+		var d int64
+		switch {
+		case '0' <= c && c <= '9':
+			d = int64(c - '0')
+		case 'a' <= c && c <= 'z':
+			d = int64(c-'a') + 10
+		default:
+			return 0, false
+		}
+		if d >= base {
+			return 0, false
+		}
+		n = n*base + d
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// coerce converts strings to numbers for arithmetic, other values are
+// returned unchanged.
+func coerce(a interface{}) interface{} {
+	if s, ok := a.(string); ok {
+		if n, ok := strToNumber(s); ok {
+			return n
+		}
+	}
+	return a
+}
+
+func hexDigit(c rune) (int64, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int64(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return int64(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return int64(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// isNumber reports whether a is an integer or a float.
+func isNumber(a interface{}) bool {
+	switch a.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number to a float.
+func toFloat(a interface{}) (float64, bool) {
+	switch a := a.(type) {
+	case int64:
+		return float64(a), true
+	case float64:
+		return a, true
+	}
+	return 0, false
+}
+
+// floatToInteger returns f as an integer if it has an exact representation.
+func floatToInteger(f float64) (int64, bool) {
+	if math.Floor(f) != f || f < -(1<<63) || f >= 1<<63 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// toInteger converts a number to an integer, floats must have an exact
+// integer value.
+func toInteger(a interface{}) (int64, bool) {
+	switch a := a.(type) {
+	case int64:
+		return a, true
+	case float64:
+		return floatToInteger(a)
+	}
+	return 0, false
+}
+
+// numToStr formats a number the way print shows it, floats with an
+// integral value keep a ".0" suffix.
+func numToStr(a interface{}) string {
+	switch a := a.(type) {
+	case int64:
+		return strconv.FormatInt(a, 10)
+	case float64:
+		switch {
+		case math.IsInf(a, 1):
+			return "inf"
+		case math.IsInf(a, -1):
+			return "-inf"
+		case math.IsNaN(a):
+			if math.Signbit(a) {
+				return "-nan"
+			}
+			return "nan"
+		}
+		s := strconv.FormatFloat(a, 'g', 14, 64)
+		if strings.Trim(s, "-0123456789") == "" {
+			s += ".0"
+		}
+		return s
+	}
+	return ""
+}
+
+// intFloorDiv is a//b for integers, b must not be 0.
+func intFloorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// intMod is a%b for integers, the result has the sign of b.
+func intMod(a, b int64) int64 {
+	r := a % b
+	if r != 0 && (r^b) < 0 {
+		r += b
+	}
+	return r
+}
+
+// floatMod is a%b for floats, the result has the sign of b.
+func floatMod(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if (m > 0 && b < 0) || (m < 0 && b > 0) {
+		m += b
+	}
+	return m
+}
+
+// shiftLeft is a<<n, negative n shifts right. Shifts are logical.
+func shiftLeft(a, n int64) int64 {
+	switch {
+	case n <= -64 || n >= 64:
+		return 0
+	case n >= 0:
+		return int64(uint64(a) << uint(n))
+	}
+	return int64(uint64(a) >> uint(-n))
+}
+
+// numLT is a<b for two numbers, integers and floats are compared exactly.
+func numLT(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x < y
+		case float64:
+			// x < y if and only if x < ceil(y)
+			if math.IsNaN(y) {
+				return false
+			}
+			if y >= 1<<63 {
+				return true
+			}
+			if y < -(1 << 63) {
+				return false
+			}
+			return x < int64(math.Ceil(y))
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			// x < y if and only if floor(x) < y
+			if math.IsNaN(x) {
+				return false
+			}
+			if x >= 1<<63 {
+				return false
+			}
+			if x < -(1 << 63) {
+				return true
+			}
+			return int64(math.Floor(x)) < y
+		case float64:
+			return x < y
+		}
+	}
+	return false
+}
+
+// numLE is a<=b for two numbers.
+func numLE(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x <= y
+		case float64:
+			// x <= y if and only if x <= floor(y)
+			if math.IsNaN(y) {
+				return false
+			}
+			if y >= 1<<63 {
+				return true
+			}
+			if y < -(1 << 63) {
+				return false
+			}
+			return x <= int64(math.Floor(y))
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			// x <= y if and only if ceil(x) <= y
+			if math.IsNaN(x) {
+				return false
+			}
+			if x >= 1<<63 {
+				return false
+			}
+			if x < -(1 << 63) {
+				return true
+			}
+			return int64(math.Ceil(x)) <= y
+		case float64:
+			return x <= y
+		}
+	}
+	return false
+}
+
+// numEQ is a==b for two numbers.
+func numEQ(a, b interface{}) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x == y
+		case float64:
+			n, ok := floatToInteger(y)
+			return ok && n == x
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			n, ok := floatToInteger(x)
+			return ok && n == y
+		case float64:
+			return x == y
+		}
+	}
+	return false
+}
@@ -0,0 +1,525 @@
+package glua
+
+import (
+	"fmt"
+	"math"
+)
+
+// getMeta returns the metatable of a, or nil.
+func (L *state) getMeta(a interface{}) *luaTable {
+	switch a := a.(type) {
+	case *luaTable:
+		return a.meta
+	case *luaUserdata:
+		return a.meta
+	case string:
+		return L.stringMeta
+	}
+	return nil
+}
+
+// metaOf returns the metamethod called event of a, or nil.
+func (L *state) metaOf(a interface{}, event string) interface{} {
+	if mt := L.getMeta(a); mt != nil {
+		return mt.get(event)
+	}
+	return nil
+}
+
+// arithMeta tries the metamethod event of a, then of b.
+func (L *state) arithMeta(a, b interface{}, event string) interface{} {
+	h := L.metaOf(a, event)
+	if h == nil {
+		h = L.metaOf(b, event)
+	}
+	if h == nil {
+		bad, second := a, isNumber(coerce(a))
+		if second {
+			bad = b
+		}
+		badOperand(second, "attempt to perform arithmetic on a %s value", valType(bad))
+	}
+	return first(L.call(h, a, b))
+}
+
+// bitwiseMeta is arithMeta for the bitwise operators.
+func (L *state) bitwiseMeta(a, b interface{}, event string) interface{} {
+	h := L.metaOf(a, event)
+	if h == nil {
+		h = L.metaOf(b, event)
+	}
+	if h == nil {
+		bad, second := a, isNumber(coerce(a))
+		if second {
+			bad = b
+		}
+		if isNumber(coerce(bad)) {
+			die("number has no integer representation")
+		}
+		badOperand(second, "attempt to perform bitwise operation on a %s value", valType(bad))
+	}
+	return first(L.call(h, a, b))
+}
+
+// operandError is raised by an operator for an operand it cannot work on.
+// The engines add the variable the operand was read from to msg, as in
+// "attempt to perform arithmetic on a nil value (local 'x')".
+type operandError struct {
+	msg    string
+	second bool // it is the right operand
+}
+
+func (e *operandError) Error() string {
+	return e.msg
+}
+
+func badOperand(second bool, format string, args ...interface{}) {
+	panic(&operandError{fmt.Sprintf(format, args...), second})
+}
+
+// nameOperand returns the panic r with the variable of the bad operand
+// added if it is an operandError, left and right describe the variables of
+// the operands as varInfo does.
+func nameOperand(r interface{}, left, right string) interface{} {
+	e, ok := r.(*operandError)
+	if !ok {
+		return r
+	}
+	if e.second {
+		return e.msg + right
+	}
+	return e.msg + left
+}
+
+// first returns the first of vs, or nil.
+func first(vs []interface{}) interface{} {
+	if len(vs) > 0 {
+		return vs[0]
+	}
+	return nil
+}
+
+// integers returns a and b if both are integers, or strings that convert
+// to integers.
+func integers(a, b interface{}) (int64, int64, bool) {
+	x, ok := coerce(a).(int64)
+	if !ok {
+		return 0, 0, false
+	}
+	y, ok := coerce(b).(int64)
+	return x, y, ok
+}
+
+// floats returns a and b converted to floats if both are numbers or
+// numeric strings.
+func floats(a, b interface{}) (float64, float64, bool) {
+	x, ok := toFloat(coerce(a))
+	if !ok {
+		return 0, 0, false
+	}
+	y, ok := toFloat(coerce(b))
+	return x, y, ok
+}
+
+// bits returns a and b converted to integers for a bitwise operation.
+func bits(a, b interface{}) (int64, int64, bool) {
+	x, ok := toInteger(coerce(a))
+	if !ok {
+		return 0, 0, false
+	}
+	y, ok := toInteger(coerce(b))
+	return x, y, ok
+}
+
+// ---
+
+// a^b
+func (L *state) opPow(a, b interface{}) interface{} {
+	if x, y, ok := floats(a, b); ok {
+		return math.Pow(x, y)
+	}
+	return L.arithMeta(a, b, "__pow")
+}
+
+// ---
+
+// not a
+func opNot(a interface{}) bool {
+	return !truthy(a)
+}
+
+// -a
+func (L *state) opNegative(a interface{}) interface{} {
+	switch x := coerce(a).(type) {
+	case int64:
+		return -x
+	case float64:
+		return -x
+	}
+	if h := L.metaOf(a, "__unm"); h != nil {
+		return first(L.call(h, a, a))
+	}
+	badOperand(false, "attempt to perform arithmetic on a %s value", valType(a))
+	return nil
+}
+
+// #a
+func (L *state) opLen(a interface{}) interface{} {
+	if s, ok := a.(string); ok {
+		return int64(len(s))
+	}
+	if h := L.metaOf(a, "__len"); h != nil {
+		return first(L.call(h, a, a))
+	}
+	if t, ok := a.(*luaTable); ok {
+		return int64(t.length())
+	}
+	badOperand(false, "attempt to get length of a %s value", valType(a))
+	return nil
+}
+
+// ~a
+func (L *state) opBnot(a interface{}) interface{} {
+	if x, ok := toInteger(coerce(a)); ok {
+		return ^x
+	}
+	if h := L.metaOf(a, "__bnot"); h != nil {
+		return first(L.call(h, a, a))
+	}
+	if isNumber(coerce(a)) {
+		panic("number has no integer representation")
+	}
+	badOperand(false, "attempt to perform bitwise operation on a %s value", valType(a))
+	return nil
+}
+
+// ---
+
+// a*b
+func (L *state) opMultiply(a, b interface{}) interface{} {
+	if x, y, ok := integers(a, b); ok {
+		return x * y
+	}
+	if x, y, ok := floats(a, b); ok {
+		return x * y
+	}
+	return L.arithMeta(a, b, "__mul")
+}
+
+// a/b
+func (L *state) opDevide(a, b interface{}) interface{} {
+	if x, y, ok := floats(a, b); ok {
+		return x / y
+	}
+	return L.arithMeta(a, b, "__div")
+}
+
+// a//b
+func (L *state) opIdiv(a, b interface{}) interface{} {
+	if x, y, ok := integers(a, b); ok {
+		if y == 0 {
+			die("attempt to perform 'n//0'")
+		}
+		return intFloorDiv(x, y)
+	}
+	if x, y, ok := floats(a, b); ok {
+		return math.Floor(x / y)
+	}
+	return L.arithMeta(a, b, "__idiv")
+}
+
+// a%b
+func (L *state) opMod(a, b interface{}) interface{} {
+	if x, y, ok := integers(a, b); ok {
+		if y == 0 {
+			die("attempt to perform 'n%%0'")
+		}
+		return intMod(x, y)
+	}
+	if x, y, ok := floats(a, b); ok {
+		return floatMod(x, y)
+	}
+	return L.arithMeta(a, b, "__mod")
+}
+
+// ---
+
+// a+b
+func (L *state) opAdd(a, b interface{}) interface{} {
+	if x, y, ok := integers(a, b); ok {
+		return x + y
+	}
+	if x, y, ok := floats(a, b); ok {
+		return x + y
+	}
+	return L.arithMeta(a, b, "__add")
+}
+
+// a-b
+func (L *state) opMinus(a, b interface{}) interface{} {
+	if x, y, ok := integers(a, b); ok {
+		return x - y
+	}
+	if x, y, ok := floats(a, b); ok {
+		return x - y
+	}
+	return L.arithMeta(a, b, "__sub")
+}
+
+// ---
+
+// a<<b
+func (L *state) opShl(a, b interface{}) interface{} {
+	if x, y, ok := bits(a, b); ok {
+		return shiftLeft(x, y)
+	}
+	return L.bitwiseMeta(a, b, "__shl")
+}
+
+// a>>b
+func (L *state) opShr(a, b interface{}) interface{} {
+	if x, y, ok := bits(a, b); ok {
+		return shiftLeft(x, -y)
+	}
+	return L.bitwiseMeta(a, b, "__shr")
+}
+
+// ---
+
+// a&b
+func (L *state) opBand(a, b interface{}) interface{} {
+	if x, y, ok := bits(a, b); ok {
+		return x & y
+	}
+	return L.bitwiseMeta(a, b, "__band")
+}
+
+// ---
+
+// a~b
+func (L *state) opBxor(a, b interface{}) interface{} {
+	if x, y, ok := bits(a, b); ok {
+		return x ^ y
+	}
+	return L.bitwiseMeta(a, b, "__bxor")
+}
+
+// ---
+
+// a|b
+func (L *state) opBor(a, b interface{}) interface{} {
+	if x, y, ok := bits(a, b); ok {
+		return x | y
+	}
+	return L.bitwiseMeta(a, b, "__bor")
+}
+
+// ---
+
+// "a".."b", numbers are converted to strings
+func (L *state) opStrAppend(a, b interface{}) interface{} {
+	if x, ok := concatString(a); ok {
+		if y, ok := concatString(b); ok {
+			return x + y
+		}
+	}
+	h := L.metaOf(a, "__concat")
+	if h == nil {
+		h = L.metaOf(b, "__concat")
+	}
+	if h == nil {
+		bad := a
+		_, second := concatString(a)
+		if second {
+			bad = b
+		}
+		badOperand(second, "attempt to concatenate a %s value", valType(bad))
+	}
+	return first(L.call(h, a, b))
+}
+
+// concatString converts a string or a number for concatenation.
+func concatString(a interface{}) (string, bool) {
+	switch a := a.(type) {
+	case string:
+		return a, true
+	case int64, float64:
+		return numToStr(a), true
+	}
+	return "", false
+}
+
+// ---
+
+// compareMeta calls the metamethod event of a or b and converts the result
+// to a boolean, ok is false if there is no metamethod.
+func (L *state) compareMeta(a, b interface{}, event string) (r bool, ok bool) {
+	h := L.metaOf(a, event)
+	if h == nil {
+		h = L.metaOf(b, event)
+	}
+	if h == nil {
+		return false, false
+	}
+	return truthy(first(L.call(h, a, b))), true
+}
+
+func compareError(a, b interface{}) {
+	ta, tb := valType(a), valType(b)
+	if ta == tb {
+		die("attempt to compare two %s values", ta)
+	}
+	die("attempt to compare %s with %s", ta, tb)
+}
+
+// a<b
+func (L *state) opLT(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return numLT(a, b)
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return x < y
+		}
+	}
+	r, ok := L.compareMeta(a, b, "__lt")
+	if !ok {
+		compareError(a, b)
+	}
+	return r
+}
+
+// a<=b
+func (L *state) opLE(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return numLE(a, b)
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return x <= y
+		}
+	}
+	if r, ok := L.compareMeta(a, b, "__le"); ok {
+		return r
+	}
+	// a <= b is not (b < a)
+	r, ok := L.compareMeta(b, a, "__lt")
+	if !ok {
+		compareError(a, b)
+	}
+	return !r
+}
+
+// a>b
+func (L *state) opGT(a, b interface{}) bool {
+	return L.opLT(b, a)
+}
+
+// a>=b
+func (L *state) opGE(a, b interface{}) bool {
+	return L.opLE(b, a)
+}
+
+// rawEqual is a==b without metamethods.
+func rawEqual(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return numEQ(a, b)
+	}
+	return a == b
+}
+
+// a==b
+func (L *state) opEQ(a, b interface{}) bool {
+	if rawEqual(a, b) {
+		return true
+	}
+	switch a.(type) {
+	case *luaTable, *luaUserdata:
+	default:
+		return false
+	}
+	if valType(a) != valType(b) {
+		return false
+	}
+	r, _ := L.compareMeta(a, b, "__eq")
+	return r
+}
+
+// a~=b
+func (L *state) opNE(a, b interface{}) bool {
+	return !L.opEQ(a, b)
+}
+
+// ---
+
+// a and b, b is only evaluated if a is true
+func opAnd(a interface{}, b func() interface{}) interface{} {
+	if !truthy(a) {
+		return a
+	}
+	return b()
+}
+
+// ---
+
+// a or b, b is only evaluated if a is false
+func opOr(a interface{}, b func() interface{}) interface{} {
+	if truthy(a) {
+		return a
+	}
+	return b()
+}
+
+// ---
+
+// maxMetaLoop limits chains of __index and __newindex.
+const maxMetaLoop = 2000
+
+// a[k]
+func (L *state) opIndex(a, k interface{}) interface{} {
+	for loop := 0; loop < maxMetaLoop; loop++ {
+		var h interface{}
+		if t, ok := a.(*luaTable); ok {
+			v := t.get(k)
+			if v != nil {
+				return v
+			}
+			if h = L.metaOf(t, "__index"); h == nil {
+				return nil
+			}
+		} else if h = L.metaOf(a, "__index"); h == nil {
+			die("attempt to index a %s value", valType(a))
+		}
+		if callable(h) {
+			return first(L.call(h, a, k))
+		}
+		a = h
+	}
+	die("'__index' chain too long; possible loop")
+	return nil
+}
+
+// a[k] = v
+func (L *state) opSetIndex(a, k, v interface{}) {
+	for loop := 0; loop < maxMetaLoop; loop++ {
+		var h interface{}
+		if t, ok := a.(*luaTable); ok {
+			if t.get(k) != nil {
+				t.set(k, v)
+				return
+			}
+			if h = L.metaOf(t, "__newindex"); h == nil {
+				t.set(k, v)
+				return
+			}
+		} else if h = L.metaOf(a, "__newindex"); h == nil {
+			die("attempt to index a %s value", valType(a))
+		}
+		if callable(h) {
+			L.call(h, a, k, v)
+			return
+		}
+		a = h
+	}
+	die("'__newindex' chain too long; possible loop")
+}
@@ -0,0 +1,141 @@
+package glua
+
+// Compiled functions are run by a register machine. An instruction packs
+// its operands into 32 bits like in the reference implementation:
+//
+//	B:9 C:9 A:8 op:6
+//	Bx:18   A:8 op:6
+//
+// A, B and C are registers of the running function. B and C may also name
+// a constant when their high bit is set (RK below). sBx is Bx minus
+// maxArgSBx, it is the signed offset of jumps.
+
+type opcode uint8
+
+const (
+	opMOVE      opcode = iota // A B     R(A) := R(B)
+	opLOADK                   // A Bx    R(A) := K(Bx)
+	opLOADBOOL                // A B C   R(A) := (B != 0); if C != 0 then pc++
+	opLOADNIL                 // A B     R(A), ..., R(A+B) := nil
+	opGETUPVAL                // A B     R(A) := U(B)
+	opGETGLOBAL               // A Bx    R(A) := globals[K(Bx)]
+	opGETTABLE                // A B C   R(A) := R(B)[RK(C)]
+	opSETGLOBAL               // A Bx    globals[K(Bx)] := R(A)
+	opSETUPVAL                // A B     U(B) := R(A)
+	opSETTABLE                // A B C   R(A)[RK(B)] := RK(C)
+	opNEWTABLE                // A B C   R(A) := {} with room for C fields
+	opSELF                    // A B C   R(A+1) := R(B); R(A) := R(B)[RK(C)]
+	opADD                     // A B C   R(A) := RK(B) + RK(C)
+	opSUB                     // A B C   R(A) := RK(B) - RK(C)
+	opMUL                     // A B C   R(A) := RK(B) * RK(C)
+	opMOD                     // A B C   R(A) := RK(B) % RK(C)
+	opPOW                     // A B C   R(A) := RK(B) ^ RK(C)
+	opDIV                     // A B C   R(A) := RK(B) / RK(C)
+	opIDIV                    // A B C   R(A) := RK(B) // RK(C)
+	opBAND                    // A B C   R(A) := RK(B) & RK(C)
+	opBOR                     // A B C   R(A) := RK(B) | RK(C)
+	opBXOR                    // A B C   R(A) := RK(B) ~ RK(C)
+	opSHL                     // A B C   R(A) := RK(B) << RK(C)
+	opSHR                     // A B C   R(A) := RK(B) >> RK(C)
+	opCONCAT                  // A B C   R(A) := RK(B) .. RK(C)
+	opUNM                     // A B     R(A) := -R(B)
+	opBNOT                    // A B     R(A) := ~R(B)
+	opNOT                     // A B     R(A) := not R(B)
+	opLEN                     // A B     R(A) := #R(B)
+	opJMP                     // sBx     pc += sBx
+	opEQ                      // A B C   if (RK(B) == RK(C)) != (A != 0) then pc++
+	opLT                      // A B C   if (RK(B) < RK(C)) != (A != 0) then pc++
+	opLE                      // A B C   if (RK(B) <= RK(C)) != (A != 0) then pc++
+	opTEST                    // A C     if truthy(R(A)) != (C != 0) then pc++
+	opCALL                    // A B C   R(A), ..., R(A+C-2) := R(A)(R(A+1), ..., R(A+B-1))
+	opRETURN                  // A B     return R(A), ..., R(A+B-2)
+	opFORCHECK                // A C     R(A) := tonumber(R(A)), C tells which 'for' value it is
+	opFORPREP                 // A sBx   start the loop R(A) = R(A), R(A+1), R(A+2), or pc += sBx
+	opFORLOOP                 // A sBx   R(A+3) := next value of the loop; pc += sBx
+	opTFORCALL                // A C     R(A+3), ..., R(A+2+C) := R(A)(R(A+1), R(A+2))
+	opTFORLOOP                // A sBx   if R(A+1) != nil then { R(A) := R(A+1); pc += sBx }
+	opSETLIST                 // A B C   add R(A+1), ..., R(A+B) to the items of R(A), C != 0 for the last
+	opCLOSURE                 // A Bx    R(A) := closure(P(Bx))
+	opVARARG                  // A B     R(A), ..., R(A+B-2) := ...
+	opCLOSE                   // A       close the upvalues of R(A) and above
+
+	numOpcodes
+)
+
+// A B C, and C B for multiple results, are 0 for "up to the top": the
+// values of the last call or ... follow the registers below them.
+
+const (
+	sizeOp = 6
+	sizeA  = 8
+	sizeB  = 9
+	sizeC  = 9
+	sizeBx = sizeB + sizeC
+
+	posA = sizeOp
+	posC = posA + sizeA
+	posB = posC + sizeC
+
+	maxArgA   = 1<<sizeA - 1
+	maxArgB   = 1<<sizeB - 1
+	maxArgC   = 1<<sizeC - 1
+	maxArgBx  = 1<<sizeBx - 1
+	maxArgSBx = maxArgBx >> 1
+
+	// bitRK marks B and C operands that are constants.
+	bitRK      = 1 << (sizeB - 1)
+	maxIndexRK = bitRK - 1
+
+	// maxRegs is the number of registers a function may use.
+	maxRegs = 250
+	// fieldsPerFlush is the number of table items SETLIST adds at once.
+	fieldsPerFlush = 50
+)
+
+type instr uint32
+
+func (i instr) op() opcode { return opcode(i & (1<<sizeOp - 1)) }
+func (i instr) a() int     { return int(i >> posA & maxArgA) }
+func (i instr) b() int     { return int(i >> posB & maxArgB) }
+func (i instr) c() int     { return int(i >> posC & maxArgC) }
+func (i instr) bx() int    { return int(i >> posC) }
+func (i instr) sbx() int   { return i.bx() - maxArgSBx }
+
+func iABC(op opcode, a, b, c int) instr {
+	return instr(op) | instr(a)<<posA | instr(b)<<posB | instr(c)<<posC
+}
+
+func iABx(op opcode, a, bx int) instr {
+	return instr(op) | instr(a)<<posA | instr(bx)<<posC
+}
+
+func iAsBx(op opcode, a, sbx int) instr {
+	return iABx(op, a, sbx+maxArgSBx)
+}
+
+// proto is a compiled function.
+type proto struct {
+	source    string // the chunk it was defined in
+	line      int
+	numParams int
+	vararg    bool
+	maxStack  int
+	code      []instr
+	consts    []interface{}
+	protos    []*proto
+	upvals    []upvalDesc
+	// lines holds the line of every instruction, for messages
+	lines []int32
+	// info names the variables of the operands of an instruction: the one
+	// it indexes or calls, as in "attempt to call a nil value (global 'f')",
+	// or the two of an operator
+	info map[int][2]string
+}
+
+// upvalDesc tells where a closure finds an upvalue when it is created:
+// in a register of the enclosing function, or in one of its upvalues.
+type upvalDesc struct {
+	name    string
+	inStack bool
+	index   int
+}
@@ -0,0 +1,246 @@
+package glua
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// openOS builds the os library.
+func (L *state) openOS() *luaTable {
+	lib := map[string]luaFunc{
+		"time": func(L *state, args ...interface{}) []interface{} {
+			if argAt(args, 0) == nil {
+				return []interface{}{time.Now().Unix()}
+			}
+			t := checkTable(args, 1, "time")
+			loc := time.Local
+			d := time.Date(
+				int(dateField(t, "year", -1)), time.Month(dateField(t, "month", -1)),
+				int(dateField(t, "day", -1)), int(dateField(t, "hour", 12)),
+				int(dateField(t, "min", 0)), int(dateField(t, "sec", 0)), 0, loc)
+			// like mktime, normalize the fields of the table
+			setDateFields(t, d)
+			return []interface{}{d.Unix()}
+		},
+		"clock": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{cpuTime()}
+		},
+		"date": func(L *state, args ...interface{}) []interface{} {
+			f := "%c"
+			if argAt(args, 0) != nil {
+				f = checkString(args, 1, "date")
+			}
+			d := time.Now()
+			if argAt(args, 1) != nil {
+				d = time.Unix(checkInteger(args, 2, "date"), 0)
+			}
+			if strings.HasPrefix(f, "!") {
+				f = f[1:]
+				d = d.UTC()
+			}
+			if strings.HasPrefix(f, "*t") {
+				t := newTable(0, 9)
+				setDateFields(t, d)
+				return []interface{}{t}
+			}
+			return []interface{}{strftime(f, d)}
+		},
+		"difftime": func(L *state, args ...interface{}) []interface{} {
+			t2 := 0.0
+			if argAt(args, 1) != nil {
+				t2 = checkNumber(args, 2, "difftime")
+			}
+			return []interface{}{checkNumber(args, 1, "difftime") - t2}
+		},
+		"getenv": func(L *state, args ...interface{}) []interface{} {
+			if v, ok := os.LookupEnv(checkString(args, 1, "getenv")); ok {
+				return []interface{}{v}
+			}
+			return []interface{}{nil}
+		},
+		"remove": func(L *state, args ...interface{}) []interface{} {
+			name := checkString(args, 1, "remove")
+			if err := os.Remove(name); err != nil {
+				return fileResult(err, name)
+			}
+			return []interface{}{true}
+		},
+		"rename": func(L *state, args ...interface{}) []interface{} {
+			from, to := checkString(args, 1, "rename"), checkString(args, 2, "rename")
+			if err := os.Rename(from, to); err != nil {
+				return fileResult(err, from)
+			}
+			return []interface{}{true}
+		},
+		"tmpname": func(L *state, args ...interface{}) []interface{} {
+			f, err := os.CreateTemp("", "lua_")
+			if err != nil {
+				die("unable to generate a unique filename")
+			}
+			f.Close()
+			return []interface{}{f.Name()}
+		},
+		"exit": func(L *state, args ...interface{}) []interface{} {
+			code := 0
+			switch a := argAt(args, 0).(type) {
+			case nil:
+			case bool:
+				if !a {
+					code = 1
+				}
+			default:
+				code = int(checkInteger(args, 1, "exit"))
+			}
+			panic(exitCode(code))
+		},
+	}
+	t := newTable(0, len(lib))
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	return t
+}
+
+// exitCode is raised by os.exit, it is not a Lua error: it unwinds every
+// pcall and coroutine up to main, which exits with it.
+type exitCode int
+
+// dateField returns the field k of a date table, def < 0 means the field
+// is required.
+func dateField(t *luaTable, k string, def int64) int64 {
+	v := t.get(k)
+	n, ok := toInteger(coerce(v))
+	if !ok {
+		if v != nil {
+			die("field '%s' is not an integer", k)
+		}
+		if def < 0 {
+			die("field '%s' missing in date table", k)
+		}
+		return def
+	}
+	if n < -1<<31 || n > 1<<31-1 {
+		die("field '%s' is out-of-bound", k)
+	}
+	return n
+}
+
+// setDateFields fills t with the fields of os.date("*t").
+func setDateFields(t *luaTable, d time.Time) {
+	t.set("year", int64(d.Year()))
+	t.set("month", int64(d.Month()))
+	t.set("day", int64(d.Day()))
+	t.set("hour", int64(d.Hour()))
+	t.set("min", int64(d.Minute()))
+	t.set("sec", int64(d.Second()))
+	t.set("wday", int64(d.Weekday())+1)
+	t.set("yday", int64(d.YearDay()))
+	t.set("isdst", d.IsDST())
+}
+
+// strftime formats d like C's strftime in the C locale.
+func strftime(f string, d time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			continue
+		}
+		i++
+		if i >= len(f) {
+			die("bad argument #1 to 'date' (invalid conversion specifier '%%')")
+		}
+		c := f[i]
+		if c == 'E' || c == 'O' {
+			// the alternative forms are the same in the C locale
+			if i+1 >= len(f) || !strings.ContainsRune(map[byte]string{'E': "cCxXyY", 'O': "deHImMSuUVwWy"}[c], rune(f[i+1])) {
+				end := i + 2
+				if end > len(f) {
+					end = len(f)
+				}
+				die("bad argument #1 to 'date' (invalid conversion specifier '%%%s')", f[i:end])
+			}
+			i++
+			c = f[i]
+		}
+		yday, wday := d.YearDay()-1, int(d.Weekday())
+		switch c {
+		case 'a':
+			b.WriteString(d.Format("Mon"))
+		case 'A':
+			b.WriteString(d.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(d.Format("Jan"))
+		case 'B':
+			b.WriteString(d.Format("January"))
+		case 'c':
+			b.WriteString(strftime("%a %b %e %H:%M:%S %Y", d))
+		case 'C':
+			fmt.Fprintf(&b, "%02d", d.Year()/100)
+		case 'd':
+			fmt.Fprintf(&b, "%02d", d.Day())
+		case 'D', 'x':
+			b.WriteString(strftime("%m/%d/%y", d))
+		case 'e':
+			fmt.Fprintf(&b, "%2d", d.Day())
+		case 'F':
+			b.WriteString(strftime("%Y-%m-%d", d))
+		case 'g':
+			y, _ := d.ISOWeek()
+			fmt.Fprintf(&b, "%02d", y%100)
+		case 'G':
+			y, _ := d.ISOWeek()
+			fmt.Fprintf(&b, "%d", y)
+		case 'H':
+			fmt.Fprintf(&b, "%02d", d.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (d.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(&b, "%03d", yday+1)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(d.Month()))
+		case 'M':
+			fmt.Fprintf(&b, "%02d", d.Minute())
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(d.Format("PM"))
+		case 'r':
+			b.WriteString(strftime("%I:%M:%S %p", d))
+		case 'R':
+			b.WriteString(strftime("%H:%M", d))
+		case 'S':
+			fmt.Fprintf(&b, "%02d", d.Second())
+		case 't':
+			b.WriteByte('\t')
+		case 'T', 'X':
+			b.WriteString(strftime("%H:%M:%S", d))
+		case 'u':
+			fmt.Fprintf(&b, "%d", (wday+6)%7+1)
+		case 'U':
+			fmt.Fprintf(&b, "%02d", (yday+7-wday)/7)
+		case 'V':
+			_, w := d.ISOWeek()
+			fmt.Fprintf(&b, "%02d", w)
+		case 'w':
+			fmt.Fprintf(&b, "%d", wday)
+		case 'W':
+			fmt.Fprintf(&b, "%02d", (yday+7-(wday+6)%7)/7)
+		case 'y':
+			fmt.Fprintf(&b, "%02d", d.Year()%100)
+		case 'Y':
+			fmt.Fprintf(&b, "%d", d.Year())
+		case 'z':
+			b.WriteString(d.Format("-0700"))
+		case 'Z':
+			b.WriteString(d.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			die("bad argument #1 to 'date' (invalid conversion specifier '%%%c')", c)
+		}
+	}
+	return b.String()
+}
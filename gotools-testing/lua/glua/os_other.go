@@ -0,0 +1,14 @@
+//go:build !unix
+
+package glua
+
+import "time"
+
+// startTime is the base of os.clock.
+var startTime = time.Now()
+
+// cpuTime stands in for the processor time with the time since start, on
+// systems without getrusage.
+func cpuTime() float64 {
+	return time.Since(startTime).Seconds()
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package glua
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the processor time used by the process in seconds, for
+// os.clock.
+func cpuTime() float64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	d := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	return d.Seconds()
+}
@@ -0,0 +1,242 @@
+package glua
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultPath is package.path when neither LUA_PATH_5_3 nor LUA_PATH is
+// set, ";;" in those variables stands for it.
+const defaultPath = "/usr/local/share/lua/5.3/?.lua;/usr/local/share/lua/5.3/?/init.lua;" +
+	"/usr/local/lib/lua/5.3/?.lua;/usr/local/lib/lua/5.3/?/init.lua;" +
+	"./?.lua;./?/init.lua"
+
+// parse runs the parser on lex. A syntax error is returned with its
+// position.
+func parse(lex *luaLexer) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			msg := fmt.Sprintf("%s:%d:%d: %v", lex.name, lex.Line()+1, lex.Column()+1, e)
+			err = &Error{Value: msg, msg: msg}
+		}
+	}()
+	yyParse(lex)
+	return nil
+}
+
+// loadChunk reads the chunk from r and returns it as a function. mode
+// tells whether it may be source ("t"), precompiled ("b") or both ("bt").
+// Source is compiled unless L evaluates the tree.
+func (L *state) loadChunk(r io.Reader, name, mode string) (*luaClosure, error) {
+	br := bufio.NewReader(r)
+	kind, allowed := "text", strings.Contains(mode, "t")
+	if b, _ := br.Peek(1); len(b) == 1 && b[0] == dumpSignature[0] {
+		kind, allowed = "binary", strings.Contains(mode, "b")
+	}
+	if !allowed {
+		msg := fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode)
+		return nil, &Error{Value: msg, msg: msg}
+	}
+	if kind == "binary" {
+		p, err := undump(br, name)
+		if err != nil {
+			return nil, err
+		}
+		return newClosure(p), nil
+	}
+	fn, err := parseChunk(br, name)
+	if err != nil {
+		return nil, err
+	}
+	if L.engine == TreeWalker {
+		return &luaClosure{fn: fn}, nil
+	}
+	p, err := compile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &luaClosure{p: p}, nil
+}
+
+// parseChunk parses the source read from r as a vararg function.
+func parseChunk(r io.Reader, name string) (*FuncExpr, error) {
+	lex := newLuaLexer(r, name)
+	if err := parse(lex); err != nil {
+		return nil, err
+	}
+	return &FuncExpr{
+		Pos:    lex.chunk.Pos,
+		Chunk:  name,
+		Vararg: true,
+		Block:  lex.chunk,
+	}, nil
+}
+
+// searchPath looks for name in the ;-separated templates of path, after
+// replacing every sep in name with rep. It returns the first readable
+// file, or the list of files it tried.
+func searchPath(name, path, sep, rep string) (string, string) {
+	if sep != "" {
+		name = strings.Replace(name, sep, rep, -1)
+	}
+	var tried strings.Builder
+	for _, tmpl := range strings.Split(path, ";") {
+		if tmpl == "" {
+			continue
+		}
+		file := strings.Replace(tmpl, "?", name, -1)
+		if f, err := os.Open(file); err == nil {
+			f.Close()
+			return file, ""
+		}
+		fmt.Fprintf(&tried, "\n\tno file '%s'", file)
+	}
+	return "", tried.String()
+}
+
+// addScriptPath makes require look next to the script in dir first.
+func (L *state) addScriptPath(dir string) {
+	p, _ := L.packageLib.get("path").(string)
+	if dir == "." || strings.Contains(";"+p+";", ";"+dir+"/?.lua;") {
+		return
+	}
+	L.packageLib.set("path", dir+"/?.lua;"+dir+"/?/init.lua;"+p)
+}
+
+// require implements the builtin require.
+func (L *state) require(name string) interface{} {
+	loaded, ok := L.packageLib.get("loaded").(*luaTable)
+	if !ok {
+		die("'package.loaded' must be a table")
+	}
+	if v := loaded.get(name); truthy(v) {
+		return v
+	}
+	for i, m := range L.loading {
+		if m == name {
+			die("circular require of module '%s' (%s -> %s)", name, strings.Join(L.loading[i:], " -> "), name)
+		}
+	}
+
+	searchers, ok := L.packageLib.get("searchers").(*luaTable)
+	if !ok {
+		die("'package.searchers' must be a table")
+	}
+	var msg strings.Builder
+	var loader, extra interface{}
+	for i := int64(1); ; i++ {
+		s := searchers.get(i)
+		if s == nil {
+			die("module '%s' not found:%s", name, msg.String())
+		}
+		rs := L.call(s, name)
+		if callable(first(rs)) {
+			loader, extra = rs[0], argAt(rs, 1)
+			break
+		}
+		if m, ok := first(rs).(string); ok {
+			msg.WriteString(m)
+		}
+	}
+
+	L.loading = append(L.loading, name)
+	n := len(L.loading)
+	defer func() {
+		L.loading = L.loading[:n-1]
+	}()
+	if v := first(L.call(loader, name, extra)); v != nil {
+		loaded.set(name, v)
+	}
+	if loaded.get(name) == nil {
+		loaded.set(name, true)
+	}
+	return loaded.get(name)
+}
+
+// openPackage builds the package library.
+func (L *state) openPackage() *luaTable {
+	t := newTable(0, 6)
+	L.packageLib = t
+
+	path := os.Getenv("LUA_PATH_5_3")
+	if path == "" {
+		path = os.Getenv("LUA_PATH")
+	}
+	if path == "" {
+		path = defaultPath
+	} else {
+		path = strings.Replace(path, ";;", ";"+defaultPath+";", -1)
+	}
+	t.set("path", path)
+	t.set("config", "/\n;\n?\n!\n-\n")
+
+	loaded := newTable(0, 8)
+	for _, lib := range []string{"coroutine", "io", "math", "os", "string", "table"} {
+		loaded.set(lib, L.globals[lib])
+	}
+	loaded.set("package", t)
+	t.set("loaded", loaded)
+	t.set("preload", newTable(0, 0))
+
+	searchers := []luaFunc{
+		func(L *state, args ...interface{}) []interface{} {
+			name := checkString(args, 1, "searcher")
+			preload, ok := t.get("preload").(*luaTable)
+			if !ok {
+				die("'package.preload' must be a table")
+			}
+			if v := preload.get(name); v != nil {
+				return []interface{}{v, ":preload:"}
+			}
+			return []interface{}{fmt.Sprintf("\n\tno field package.preload['%s']", name)}
+		},
+		func(L *state, args ...interface{}) []interface{} {
+			name := checkString(args, 1, "searcher")
+			if open, ok := L.goModules[name]; ok {
+				return []interface{}{newFunc(open), ":go:"}
+			}
+			return []interface{}{fmt.Sprintf("\n\tno Go module '%s'", name)}
+		},
+		func(L *state, args ...interface{}) []interface{} {
+			name := checkString(args, 1, "searcher")
+			path, ok := t.get("path").(string)
+			if !ok {
+				die("'package.path' must be a string")
+			}
+			file, tried := searchPath(name, path, ".", "/")
+			if file == "" {
+				return []interface{}{tried}
+			}
+			fn, err := L.loadFile(file)
+			if err != nil {
+				die("error loading module '%s' from file '%s':\n\t%s", name, file, err)
+			}
+			return []interface{}{fn, file}
+		},
+	}
+	st := newTable(len(searchers), 0)
+	for i, s := range searchers {
+		st.set(int64(i+1), newFunc(s))
+	}
+	t.set("searchers", st)
+
+	t.set("searchpath", newFunc(func(L *state, args ...interface{}) []interface{} {
+		name, path := checkString(args, 1, "searchpath"), checkString(args, 2, "searchpath")
+		sep, rep := ".", "/"
+		if argAt(args, 2) != nil {
+			sep = checkString(args, 3, "searchpath")
+		}
+		if argAt(args, 3) != nil {
+			rep = checkString(args, 4, "searchpath")
+		}
+		file, tried := searchPath(name, path, sep, rep)
+		if file == "" {
+			return []interface{}{nil, tried}
+		}
+		return []interface{}{file}
+	}))
+	return t
+}
@@ -0,0 +1,530 @@
+package glua
+
+import (
+	"strings"
+)
+
+// Lua patterns, a port of the matcher in the reference lstrlib.c. Indices
+// into the subject and the pattern are byte offsets, a failed match is -1.
+
+const (
+	maxCaptures  = 32
+	maxMatchCall = 200
+	capUnclosed  = -1
+	capPosition  = -2
+	// patSpecials sends a pattern to the matcher instead of a plain find,
+	// ')' is there so that an unmatched one is an error for find too
+	patSpecials = "^$*+?.()[%-"
+)
+
+type matchState struct {
+	src     string
+	pat     string
+	level   int
+	depth   int
+	capture [maxCaptures]struct{ init, len int }
+}
+
+func newMatchState(src, pat string) *matchState {
+	return &matchState{src: src, pat: pat, depth: maxMatchCall}
+}
+
+// reset prepares ms for a match at another position.
+func (ms *matchState) reset() {
+	ms.level = 0
+	ms.depth = maxMatchCall
+}
+
+// classEnd returns the end of the single character class at p.
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	if c == '%' {
+		if p >= len(ms.pat) {
+			die("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		// look for a ']', the first one may be part of the set
+		for {
+			if p >= len(ms.pat) {
+				die("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' {
+				if p >= len(ms.pat) {
+					die("malformed pattern (missing ']')")
+				}
+				p++
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+// matchClass reports whether c belongs to the class %cl.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = '0' <= c && c <= '9'
+	case 'g':
+		res = 32 < c && c < 127
+	case 'l':
+		res = 'a' <= c && c <= 'z'
+	case 'p':
+		res = 32 < c && c < 127 && !isAlnum(c)
+	case 's':
+		res = c == ' ' || ('\t' <= c && c <= '\r')
+	case 'u':
+		res = 'A' <= c && c <= 'Z'
+	case 'w':
+		res = isAlnum(c)
+	case 'x':
+		res = ('0' <= c && c <= '9') || ('a' <= c|0x20 && c|0x20 <= 'f')
+	default:
+		return cl == c
+	}
+	if 'A' <= cl && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || ('0' <= c && c <= '9')
+}
+
+// matchBracketClass reports whether c belongs to the set [...] from p to
+// ec, ec being the index of the closing ']'.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			if ms.pat[p] <= c && c <= ms.pat[p+2] {
+				return sig
+			}
+			p += 2
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+// singleMatch reports whether the character at s matches the class from
+// p to ep.
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the subject from s and returns
+// the end of the match.
+func (ms *matchState) match(s, p int) int {
+	ms.depth--
+	if ms.depth == 0 {
+		die("pattern too complex")
+	}
+	defer func() { ms.depth++ }()
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnclosed)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 < len(ms.pat) {
+				switch ms.pat[p+1] {
+				case 'b':
+					if s = ms.matchBalance(s, p+2); s == -1 {
+						return -1
+					}
+					p += 4
+					continue
+				case 'f':
+					p += 2
+					if p >= len(ms.pat) || ms.pat[p] != '[' {
+						die("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+						return -1
+					}
+					p = ep
+					continue
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+					if s = ms.matchCapture(s, ms.pat[p+1]); s == -1 {
+						return -1
+					}
+					p += 2
+					continue
+				}
+			}
+		}
+		// a single character class, maybe followed by a repetition
+		ep := ms.classEnd(p)
+		if !ms.singleMatch(s, p, ep) {
+			if ep < len(ms.pat) && (ms.pat[ep] == '*' || ms.pat[ep] == '?' || ms.pat[ep] == '-') {
+				// accept the empty match and go on
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		if ep < len(ms.pat) {
+			switch ms.pat[ep] {
+			case '?':
+				if r := ms.match(s+1, ep+1); r != -1 {
+					return r
+				}
+				p = ep + 1
+				continue
+			case '+':
+				return ms.maxExpand(s+1, p, ep)
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		s++
+		p = ep
+	}
+	return s
+}
+
+// maxExpand matches as many repetitions of the class as possible.
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if r := ms.match(s+i, ep+1); r != -1 {
+			return r
+		}
+	}
+	return -1
+}
+
+// minExpand matches as few repetitions of the class as possible.
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if r := ms.match(s, ep+1); r != -1 {
+			return r
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		die("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.level--
+	}
+	return r
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.capture[l].len = capUnclosed
+	}
+	return r
+}
+
+func (ms *matchState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.capture[level].len == capUnclosed {
+			return level
+		}
+	}
+	die("invalid pattern capture")
+	return 0
+}
+
+// matchBalance matches %bxy at p.
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		die("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			cont--
+			if cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+// matchCapture matches a back reference %1-%9.
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	c := ms.src[ms.capture[i].init : ms.capture[i].init+ms.capture[i].len]
+	if strings.HasPrefix(ms.src[s:], c) {
+		return s + len(c)
+	}
+	return -1
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l - '1')
+	if i < 0 || i >= ms.level || ms.capture[i].len == capUnclosed {
+		die("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+// getCapture returns capture i of the match from s to e, the whole match
+// if the pattern has no captures.
+func (ms *matchState) getCapture(i, s, e int) interface{} {
+	if i >= ms.level {
+		if i != 0 {
+			die("invalid capture index %%%d", i+1)
+		}
+		return ms.src[s:e]
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnclosed:
+		die("unfinished capture")
+	case capPosition:
+		return int64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// captures returns all captures of the match from s to e. If whole is
+// true and there are no captures the whole match is returned.
+func (ms *matchState) captures(s, e int, whole bool) []interface{} {
+	n := ms.level
+	if n == 0 && whole {
+		n = 1
+	}
+	rs := make([]interface{}, n)
+	for i := range rs {
+		rs[i] = ms.getCapture(i, s, e)
+	}
+	return rs
+}
+
+// strFind implements string.find and string.match.
+func strFind(args []interface{}, fname string, find bool) []interface{} {
+	s := checkString(args, 1, fname)
+	pat := checkString(args, 2, fname)
+	init := strIndex(optInteger(args, 3, fname, 1), len(s))
+	if init < 1 {
+		init = 1
+	}
+	if init > int64(len(s))+1 {
+		return []interface{}{nil}
+	}
+	if find && (truthy(argAt(args, 3)) || !strings.ContainsAny(pat, patSpecials)) {
+		// plain search
+		i := strings.Index(s[init-1:], pat)
+		if i < 0 {
+			return []interface{}{nil}
+		}
+		i += int(init) - 1
+		return []interface{}{int64(i + 1), int64(i + len(pat))}
+	}
+	ms := newMatchState(s, pat)
+	p := 0
+	anchor := len(pat) > 0 && pat[0] == '^'
+	if anchor {
+		p = 1
+	}
+	for i := int(init) - 1; i <= len(s); i++ {
+		ms.reset()
+		if e := ms.match(i, p); e != -1 {
+			if find {
+				return append([]interface{}{int64(i + 1), int64(e)}, ms.captures(-1, -1, false)...)
+			}
+			return ms.captures(i, e, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []interface{}{nil}
+}
+
+// gmatch implements string.gmatch.
+func gmatch(s, pat string) *luaFunc {
+	ms := newMatchState(s, pat)
+	src, last := 0, -1
+	return newFunc(func(L *state, args ...interface{}) []interface{} {
+		for ; src <= len(s); src++ {
+			ms.reset()
+			if e := ms.match(src, 0); e != -1 && e != last {
+				start := src
+				src, last = e, e
+				return ms.captures(start, e, true)
+			}
+		}
+		return []interface{}{nil}
+	})
+}
+
+// gsub implements string.gsub.
+func (L *state) gsub(args []interface{}) []interface{} {
+	src := checkString(args, 1, "gsub")
+	pat := checkString(args, 2, "gsub")
+	repl := argAt(args, 2)
+	switch repl.(type) {
+	case string, int64, float64, *luaTable, *luaFunc, *luaClosure:
+	default:
+		die("bad argument #3 to 'gsub' (string/function/table expected, got %s)", argType(args, 2))
+	}
+	max := optInteger(args, 4, "gsub", int64(len(src)+1))
+	p := 0
+	anchor := len(pat) > 0 && pat[0] == '^'
+	if anchor {
+		p = 1
+	}
+	ms := newMatchState(src, pat)
+	var b strings.Builder
+	s, last := 0, -1
+	n := int64(0)
+	for n < max {
+		ms.reset()
+		if e := ms.match(s, p); e != -1 && e != last {
+			n++
+			ms.addValue(L, &b, s, e, repl)
+			s, last = e, e
+		} else if s < len(src) {
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	return []interface{}{b.String(), n}
+}
+
+// addValue writes the replacement for the match from s to e.
+func (ms *matchState) addValue(L *state, b *strings.Builder, s, e int, repl interface{}) {
+	var v interface{}
+	switch r := repl.(type) {
+	case *luaTable:
+		v = L.opIndex(r, ms.getCapture(0, s, e))
+	case *luaFunc, *luaClosure:
+		v = first(L.call(r, ms.captures(s, e, true)...))
+	default:
+		r, _ = concatString(r)
+		ms.addString(b, s, e, r.(string))
+		return
+	}
+	if !truthy(v) {
+		// keep the original text
+		b.WriteString(ms.src[s:e])
+		return
+	}
+	str, ok := concatString(v)
+	if !ok {
+		die("invalid replacement value (a %s)", valType(v))
+	}
+	b.WriteString(str)
+}
+
+// addString writes a replacement string, expanding %0-%9 and %%.
+func (ms *matchState) addString(b *strings.Builder, s, e int, r string) {
+	for i := 0; i < len(r); i++ {
+		if r[i] != '%' {
+			b.WriteByte(r[i])
+			continue
+		}
+		i++
+		switch {
+		case i < len(r) && r[i] == '%':
+			b.WriteByte('%')
+		case i < len(r) && r[i] == '0':
+			b.WriteString(ms.src[s:e])
+		case i < len(r) && '1' <= r[i] && r[i] <= '9':
+			v, _ := concatString(ms.getCapture(int(r[i]-'1'), s, e))
+			b.WriteString(v)
+		default:
+			die("invalid use of '%%' in replacement string")
+		}
+	}
+}
@@ -0,0 +1,586 @@
+package glua
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Go values other than numbers, strings and booleans are bound by
+// reflection: scripts see them as userdata whose metatable looks up fields,
+// methods and elements when they are used.
+//
+// Structs, and pointers to them, have their exported fields and methods.
+// Methods are called with a colon, p:Move(1, 2). Slices and arrays are
+// indexed from 1 like sequences, maps by their keys, and both have # and
+// work with pairs. Functions can be called. Arguments are converted to the
+// Go parameter types, tables to slices, arrays, maps and structs, and Lua
+// functions to Go functions. A function whose last result is an error
+// raises it instead of returning it.
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// methodKey identifies a method in the cache of State.goMethods.
+type methodKey struct {
+	t    reflect.Type
+	name string
+}
+
+// openReflect makes the metatable of bound Go values.
+func (L *state) openReflect() {
+	L.goMethods = map[methodKey]*luaFunc{}
+	meta := map[string]luaFunc{
+		"__index": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.goIndex(checkGo(args, 1, "__index"), argAt(args, 1))}
+		},
+		"__newindex": func(L *state, args ...interface{}) []interface{} {
+			L.goSetIndex(checkGo(args, 1, "__newindex"), argAt(args, 1), argAt(args, 2))
+			return nil
+		},
+		"__len": func(L *state, args ...interface{}) []interface{} {
+			rv := deref(checkGo(args, 1, "__len"))
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan, reflect.String:
+				return []interface{}{int64(rv.Len())}
+			}
+			die("attempt to get length of a userdata value (%s)", rv.Type())
+			return nil
+		},
+		"__call": func(L *state, args ...interface{}) []interface{} {
+			rv := checkGo(args, 1, "__call")
+			if rv.Kind() != reflect.Func {
+				die("attempt to call a userdata value (%s)", rv.Type())
+			}
+			return L.callGo(rv, nil, args[1:], "?")
+		},
+		"__pairs": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.goNext(checkGo(args, 1, "__pairs")), args[0], nil}
+		},
+		"__eq": func(L *state, args ...interface{}) []interface{} {
+			a, ok1 := toGoValue(argAt(args, 0))
+			b, ok2 := toGoValue(argAt(args, 1))
+			return []interface{}{ok1 && ok2 && goEqual(a, b)}
+		},
+		"__tostring": func(L *state, args ...interface{}) []interface{} {
+			rv := checkGo(args, 1, "__tostring")
+			if s, ok := stringer(rv); ok {
+				return []interface{}{s.String()}
+			}
+			return []interface{}{fmt.Sprintf("%s: %p", rv.Type(), args[0])}
+		},
+	}
+	L.goMeta = newTable(0, len(meta))
+	for name, fn := range meta {
+		L.goMeta.set(name, newFunc(fn))
+	}
+}
+
+// toGoValue returns the Go value bound to a.
+func toGoValue(a interface{}) (reflect.Value, bool) {
+	if u, ok := a.(*luaUserdata); ok {
+		rv, ok := u.value.(reflect.Value)
+		return rv, ok
+	}
+	return reflect.Value{}, false
+}
+
+// checkGo returns the n-th argument of the builtin fname, which must be a
+// bound Go value.
+func checkGo(args []interface{}, n int, fname string) reflect.Value {
+	rv, ok := toGoValue(argAt(args, n-1))
+	if !ok {
+		die("bad argument #%d to '%s' (Go value expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return rv
+}
+
+// deref returns what the pointer rv points to, other values are returned
+// as they are.
+func deref(rv reflect.Value) reflect.Value {
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		return rv.Elem()
+	}
+	return rv
+}
+
+// bind returns the value scripts see for the Go value rv.
+func (L *state) bind(rv reflect.Value) interface{} {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			// too big for an integer
+			return float64(u)
+		}
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Interface:
+		return L.bind(rv.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if rv.IsNil() {
+			return nil
+		}
+	case reflect.Struct, reflect.Array:
+		if !rv.CanAddr() {
+			// work on a copy, so that scripts can set fields and elements
+			c := reflect.New(rv.Type()).Elem()
+			c.Set(rv)
+			rv = c
+		}
+	}
+	return &luaUserdata{rv, L.goMeta}
+}
+
+// goValue returns the value Go code sees for the Lua value v, bound Go
+// values are unwrapped.
+func goValue(v interface{}) interface{} {
+	if rv, ok := toGoValue(v); ok {
+		return rv.Interface()
+	}
+	return v
+}
+
+// toGo converts the Lua value v to the Go type t, ok is false if it cannot
+// be converted.
+func (L *state) toGo(v interface{}, t reflect.Type) (rv reflect.Value, ok bool) {
+	if g, ok := toGoValue(v); ok {
+		switch {
+		case g.Type().AssignableTo(t):
+			return g, true
+		case g.CanAddr() && g.Addr().Type().AssignableTo(t):
+			return g.Addr(), true
+		case g.Kind() == reflect.Ptr && !g.IsNil() && g.Elem().Type().AssignableTo(t):
+			return g.Elem(), true
+		}
+		return rv, false
+	}
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+			return reflect.Zero(t), true
+		}
+		return rv, false
+	}
+	rv = reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		x := reflect.ValueOf(v)
+		if !x.Type().Implements(t) {
+			return rv, false
+		}
+		rv.Set(x)
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return rv, false
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInteger(coerce(v))
+		if !ok || rv.OverflowInt(i) {
+			return rv, false
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := toInteger(coerce(v))
+		if !ok || i < 0 || rv.OverflowUint(uint64(i)) {
+			return rv, false
+		}
+		rv.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(coerce(v))
+		if !ok {
+			return rv, false
+		}
+		rv.SetFloat(f)
+	case reflect.String:
+		s, ok := concatString(v)
+		if !ok {
+			return rv, false
+		}
+		rv.SetString(s)
+	case reflect.Slice:
+		if s, ok := v.(string); ok && t.Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(s)).Convert(t), true
+		}
+		tab, ok := v.(*luaTable)
+		if !ok {
+			return rv, false
+		}
+		n := tab.length()
+		rv.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			e, ok := L.toGo(tab.get(int64(i+1)), t.Elem())
+			if !ok {
+				return rv, false
+			}
+			rv.Index(i).Set(e)
+		}
+	case reflect.Array:
+		tab, ok := v.(*luaTable)
+		if !ok {
+			return rv, false
+		}
+		for i := 0; i < t.Len(); i++ {
+			if x := tab.get(int64(i + 1)); x != nil {
+				e, ok := L.toGo(x, t.Elem())
+				if !ok {
+					return rv, false
+				}
+				rv.Index(i).Set(e)
+			}
+		}
+	case reflect.Map:
+		tab, ok := v.(*luaTable)
+		if !ok {
+			return rv, false
+		}
+		rv.Set(reflect.MakeMap(t))
+		for k, x := tab.next(nil); k != nil; k, x = tab.next(k) {
+			gk, ok1 := L.toGo(k, t.Key())
+			gx, ok2 := L.toGo(x, t.Elem())
+			if !ok1 || !ok2 {
+				return rv, false
+			}
+			rv.SetMapIndex(gk, gx)
+		}
+	case reflect.Struct:
+		tab, ok := v.(*luaTable)
+		if !ok {
+			return rv, false
+		}
+		for k, x := tab.next(nil); k != nil; k, x = tab.next(k) {
+			name, ok := k.(string)
+			if !ok {
+				return rv, false
+			}
+			f, ok := field(rv, name)
+			if !ok {
+				return rv, false
+			}
+			gx, ok := L.toGo(x, f.Type())
+			if !ok {
+				return rv, false
+			}
+			f.Set(gx)
+		}
+	case reflect.Ptr:
+		e, ok := L.toGo(v, t.Elem())
+		if !ok {
+			return rv, false
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(e)
+		rv.Set(p)
+	case reflect.Func:
+		if !callable(v) && L.metaOf(v, "__call") == nil {
+			return rv, false
+		}
+		rv.Set(L.makeFunc(v, t))
+	default:
+		return rv, false
+	}
+	return rv, true
+}
+
+// makeFunc returns a Go function of type t that calls the Lua function fn.
+// If t has an error as its last result, a Lua error is returned there,
+// otherwise it panics like the errors of the scripts and is only caught if
+// the function is called from a script.
+func (L *state) makeFunc(fn interface{}, t reflect.Type) reflect.Value {
+	hasErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		run := func() {
+			var args []interface{}
+			for i, a := range in {
+				if i == len(in)-1 && t.IsVariadic() {
+					for j := 0; j < a.Len(); j++ {
+						args = append(args, L.bind(a.Index(j)))
+					}
+					break
+				}
+				args = append(args, L.bind(a))
+			}
+			rs := L.call(fn, args...)
+			for i := range out {
+				r := argAt(rs, i)
+				if r == nil || hasErr && i == len(out)-1 {
+					// missing results are zero values
+					out[i] = reflect.Zero(t.Out(i))
+					continue
+				}
+				v, ok := L.toGo(r, t.Out(i))
+				if !ok {
+					die("cannot use %s as %s in result #%d", valType(r), t.Out(i), i+1)
+				}
+				out[i] = v
+			}
+		}
+		if !hasErr {
+			L.busy(run)
+			return out
+		}
+		if err := L.protect(run); err != nil {
+			for i := range out {
+				out[i] = reflect.Zero(t.Out(i))
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+		}
+		return out
+	})
+}
+
+// callGo calls the Go function fn with the arguments in, which come first,
+// then with args converted to the types of the remaining parameters. name
+// is used in the messages.
+func (L *state) callGo(fn reflect.Value, in []reflect.Value, args []interface{}, name string) []interface{} {
+	t := fn.Type()
+	off, fixed := len(in), t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	for i := off; i < fixed; i++ {
+		in = append(in, L.goArg(args, i-off, t.In(i), name))
+	}
+	if t.IsVariadic() {
+		et := t.In(fixed).Elem()
+		for j := fixed - off; j < len(args); j++ {
+			in = append(in, L.goArg(args, j, et, name))
+		}
+	}
+	out := fn.Call(in)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err := out[n-1]; !err.IsNil() {
+			raise(err.Interface().(error))
+		}
+		out = out[:n-1]
+	}
+	rs := make([]interface{}, len(out))
+	for i, o := range out {
+		rs[i] = L.bind(o)
+	}
+	return rs
+}
+
+// goArg converts args[j] to the type t of a parameter of the function
+// name.
+func (L *state) goArg(args []interface{}, j int, t reflect.Type, name string) reflect.Value {
+	v, ok := L.toGo(argAt(args, j), t)
+	if !ok {
+		die("bad argument #%d to '%s' (%s expected, got %s)", j+1, name, t, argType(args, j))
+	}
+	return v
+}
+
+// method returns the method name of rv as a function that takes the
+// receiver as its first argument, or nil.
+func (L *state) method(rv reflect.Value, name string) interface{} {
+	t := rv.Type()
+	if rv.CanAddr() {
+		// the pointer has the methods of both receivers
+		t = reflect.PtrTo(t)
+	}
+	k := methodKey{t, name}
+	if fn, ok := L.goMethods[k]; ok {
+		return fn
+	}
+	m, ok := t.MethodByName(name)
+	if !ok {
+		return nil
+	}
+	fn := newFunc(func(L *state, args ...interface{}) []interface{} {
+		self, ok := L.toGo(argAt(args, 0), t)
+		if !ok {
+			die("calling '%s' on bad self (%s expected, got %s)", name, t, argType(args, 0))
+		}
+		return L.callGo(m.Func, []reflect.Value{self}, args[1:], name)
+	})
+	L.goMethods[k] = fn
+	return fn
+}
+
+// field returns the exported field name of the struct rv. It raises an
+// error if the field is promoted through a nil embedded pointer.
+func field(rv reflect.Value, name string) (reflect.Value, bool) {
+	f, ok := rv.Type().FieldByName(name)
+	if !ok || f.PkgPath != "" {
+		return reflect.Value{}, false
+	}
+	v, err := rv.FieldByIndexErr(f.Index)
+	if err != nil {
+		die("cannot reach field %s of %s through a nil embedded pointer", name, rv.Type())
+	}
+	return v, v.CanInterface()
+}
+
+// goIndex is rv[k].
+func (L *state) goIndex(rv reflect.Value, k interface{}) interface{} {
+	name, isName := k.(string)
+	if isName {
+		if m := L.method(rv, name); m != nil {
+			return m
+		}
+	}
+	e := deref(rv)
+	switch e.Kind() {
+	case reflect.Struct:
+		if isName {
+			if f, ok := field(e, name); ok {
+				return L.bind(f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if i, ok := toInteger(k); ok {
+			if i < 1 || i > int64(e.Len()) {
+				return nil
+			}
+			return L.bind(e.Index(int(i - 1)))
+		}
+		if !isName {
+			return nil
+		}
+	case reflect.Map:
+		if key, ok := L.toGo(k, e.Type().Key()); ok {
+			return L.bind(e.MapIndex(key))
+		}
+		return nil
+	}
+	die("%s has no field or method %s", rv.Type(), L.tostr(k))
+	return nil
+}
+
+// goSetIndex is rv[k] = v.
+func (L *state) goSetIndex(rv reflect.Value, k, v interface{}) {
+	e := deref(rv)
+	var dst reflect.Value
+	switch e.Kind() {
+	case reflect.Struct:
+		name, _ := k.(string)
+		f, ok := field(e, name)
+		if !ok {
+			die("%s has no field %s", rv.Type(), L.tostr(k))
+		}
+		if !f.CanSet() {
+			die("cannot set field %s of %s", name, rv.Type())
+		}
+		dst = f
+	case reflect.Slice, reflect.Array:
+		i, ok := toInteger(k)
+		if !ok || i < 1 || i > int64(e.Len()) {
+			die("index %s out of range for %s of length %d", L.tostr(k), rv.Type(), e.Len())
+		}
+		dst = e.Index(int(i - 1))
+		if !dst.CanSet() {
+			die("cannot set element of %s", rv.Type())
+		}
+	case reflect.Map:
+		key, ok := L.toGo(k, e.Type().Key())
+		if !ok {
+			die("cannot use %s as %s in map key", valType(k), e.Type().Key())
+		}
+		if v == nil {
+			e.SetMapIndex(key, reflect.Value{})
+			return
+		}
+		x, ok := L.toGo(v, e.Type().Elem())
+		if !ok {
+			die("cannot use %s as %s in map value", valType(v), e.Type().Elem())
+		}
+		e.SetMapIndex(key, x)
+		return
+	default:
+		die("attempt to index a userdata value (%s)", rv.Type())
+	}
+	x, ok := L.toGo(v, dst.Type())
+	if !ok {
+		die("cannot use %s as %s in assignment", valType(v), dst.Type())
+	}
+	dst.Set(x)
+}
+
+// goNext returns the iterator pairs uses for rv.
+func (L *state) goNext(rv reflect.Value) *luaFunc {
+	e := deref(rv)
+	switch e.Kind() {
+	case reflect.Slice, reflect.Array:
+		i := 0
+		return newFunc(func(L *state, args ...interface{}) []interface{} {
+			if i >= e.Len() {
+				return []interface{}{nil}
+			}
+			i++
+			return []interface{}{int64(i), L.bind(e.Index(i - 1))}
+		})
+	case reflect.Map:
+		it := e.MapRange()
+		return newFunc(func(L *state, args ...interface{}) []interface{} {
+			if !it.Next() {
+				return []interface{}{nil}
+			}
+			return []interface{}{L.bind(it.Key()), L.bind(it.Value())}
+		})
+	case reflect.Struct:
+		i := 0
+		return newFunc(func(L *state, args ...interface{}) []interface{} {
+			for ; i < e.NumField(); i++ {
+				f := e.Type().Field(i)
+				if f.PkgPath == "" && !f.Anonymous {
+					i++
+					return []interface{}{f.Name, L.bind(e.Field(i - 1))}
+				}
+			}
+			return []interface{}{nil}
+		})
+	}
+	die("attempt to iterate over a userdata value (%s)", rv.Type())
+	return nil
+}
+
+// goEqual reports whether a and b are the same Go value, slices and maps
+// are equal if they share their elements.
+func goEqual(a, b reflect.Value) bool {
+	switch {
+	case a.Type() != b.Type():
+		return false
+	case a.Type().Comparable():
+		return interfaceEqual(a, b)
+	case a.Kind() == reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	case a.Kind() == reflect.Map:
+		return a.Pointer() == b.Pointer()
+	}
+	return false
+}
+
+// interfaceEqual is a == b for a comparable type. Comparing panics if
+// interfaces inside the values hold slices, maps or functions, such values
+// are only equal to themselves.
+func interfaceEqual(a, b reflect.Value) (eq bool) {
+	defer func() {
+		if recover() != nil {
+			eq = a.CanAddr() && b.CanAddr() && a.UnsafeAddr() == b.UnsafeAddr()
+		}
+	}()
+	return a.Interface() == b.Interface()
+}
+
+// stringer returns rv as a fmt.Stringer if it, or its address, is one.
+func stringer(rv reflect.Value) (fmt.Stringer, bool) {
+	if s, ok := rv.Interface().(fmt.Stringer); ok {
+		return s, true
+	}
+	if rv.CanAddr() {
+		s, ok := rv.Addr().Interface().(fmt.Stringer)
+		return s, ok
+	}
+	return nil, false
+}
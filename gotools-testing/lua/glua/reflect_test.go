@@ -0,0 +1,230 @@
+package glua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type point struct{ X, Y int }
+
+func (p *point) Move(dx, dy int) { p.X += dx; p.Y += dy }
+
+func (p point) String() string { return fmt.Sprintf("(%d, %d)", p.X, p.Y) }
+
+func (p point) Dist() int { return p.X + p.Y }
+
+type inner struct{ V int }
+
+type outer struct {
+	*inner
+	Name string
+}
+
+type holder struct{ A interface{} }
+
+// doString runs src in a new State with the given globals and fails the
+// test on an error.
+func doString(t *testing.T, src string, globals map[string]interface{}) *State {
+	t.Helper()
+	L := NewState()
+	for k, v := range globals {
+		L.SetGlobal(k, v)
+	}
+	if err := L.DoString(src); err != nil {
+		t.Fatal(err)
+	}
+	return L
+}
+
+// doError runs src and returns the message of the error it raises.
+func doError(t *testing.T, src string, globals map[string]interface{}) string {
+	t.Helper()
+	L := NewState()
+	for k, v := range globals {
+		L.SetGlobal(k, v)
+	}
+	err := L.DoString(src)
+	if err == nil {
+		t.Fatalf("%q: no error", src)
+	}
+	return err.Error()
+}
+
+func TestStructFields(t *testing.T) {
+	p := &point{X: 1, Y: 2}
+	doString(t, `
+		assert(p.X == 1 and p.Y == 2)
+		p.X = 10
+		assert(p.X == 10)
+		local ok, err = pcall(function() return p.Z end)
+		assert(not ok and err:find("no field or method Z"))
+		ok, err = pcall(function() p.X = "a" end)
+		assert(not ok and err:find("cannot use string as int"))
+		local n = 0
+		for k, v in pairs(p) do n = n + v end
+		assert(n == 12)
+		assert(tostring(p) == "(10, 2)")
+	`, map[string]interface{}{"p": p})
+	if p.X != 10 {
+		t.Errorf("p.X = %d, want 10", p.X)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	p := &point{X: 1, Y: 2}
+	doString(t, `
+		p:Move(1, 1)
+		assert(p:Dist() == 5)
+		local v = v
+		assert(v:Dist() == 7)
+		v:Move(1, 0)
+		assert(v.X == 4)
+		local ok, err = pcall(p.Move, 1, 2)
+		assert(not ok and err:find("bad self"))
+		ok, err = pcall(p.Move, p, "x")
+		assert(not ok and err:find("bad argument #1 to 'Move'"))
+	`, map[string]interface{}{"p": p, "v": point{X: 3, Y: 4}})
+	if *p != (point{2, 3}) {
+		t.Errorf("p = %v, want (2, 3)", *p)
+	}
+}
+
+func TestSlicesAndMaps(t *testing.T) {
+	s := []string{"a", "b", "c"}
+	m := map[string]int{"x": 1, "y": 2}
+	doString(t, `
+		assert(#s == 3)
+		assert(s[1] == "a" and s[3] == "c")
+		assert(s[0] == nil and s[4] == nil)
+		s[2] = "B"
+		local all = ""
+		for i, v in pairs(s) do all = all .. i .. v end
+		assert(all == "1a2B3c")
+		local ok, err = pcall(function() s[4] = "d" end)
+		assert(not ok and err:find("out of range"))
+
+		assert(#m == 2)
+		assert(m.x == 1 and m.z == nil)
+		m.z = 3
+		m.x = nil
+		local sum = 0
+		for k, v in pairs(m) do sum = sum + v end
+		assert(sum == 5)
+	`, map[string]interface{}{"s": s, "m": m})
+	if s[1] != "B" {
+		t.Errorf("s[1] = %q, want B", s[1])
+	}
+	if _, ok := m["x"]; ok || m["z"] != 3 {
+		t.Errorf("m = %v, want map[y:2 z:3]", m)
+	}
+}
+
+func TestTablesToGo(t *testing.T) {
+	var gotSlice []int
+	var gotMap map[string]float64
+	var gotPoint point
+	doString(t, `
+		setSlice({1, 2, 3})
+		setMap({a = 1.5, b = 2})
+		setPoint({X = 1, Y = 2})
+		local ok, err = pcall(setSlice, {1, "x"})
+		assert(not ok and err:find("bad argument #1"))
+		ok, err = pcall(setPoint, {Z = 1})
+		assert(not ok and err:find("bad argument #1"))
+	`, map[string]interface{}{
+		"setSlice": func(s []int) { gotSlice = s },
+		"setMap":   func(m map[string]float64) { gotMap = m },
+		"setPoint": func(p point) { gotPoint = p },
+	})
+	if fmt.Sprint(gotSlice) != "[1 2 3]" {
+		t.Errorf("slice = %v, want [1 2 3]", gotSlice)
+	}
+	if gotMap["a"] != 1.5 || gotMap["b"] != 2 || len(gotMap) != 2 {
+		t.Errorf("map = %v, want map[a:1.5 b:2]", gotMap)
+	}
+	if gotPoint != (point{1, 2}) {
+		t.Errorf("point = %v, want (1, 2)", gotPoint)
+	}
+}
+
+func TestLuaFuncToGo(t *testing.T) {
+	var double func(int) int
+	var check func(string) (int, error)
+	doString(t, `
+		setDouble(function(x) return x * 2 end)
+		setCheck(function(s)
+			if s == "" then error("empty") end
+			return #s
+		end)
+	`, map[string]interface{}{
+		"setDouble": func(f func(int) int) { double = f },
+		"setCheck":  func(f func(string) (int, error)) { check = f },
+	})
+	if n := double(21); n != 42 {
+		t.Errorf("double(21) = %d, want 42", n)
+	}
+	if n, err := check("abc"); n != 3 || err != nil {
+		t.Errorf("check(\"abc\") = %d, %v, want 3, nil", n, err)
+	}
+	if _, err := check(""); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("check(\"\") returned %v, want the error empty", err)
+	}
+}
+
+func TestErrorResults(t *testing.T) {
+	globals := map[string]interface{}{
+		"parse": func(s string) (int, error) {
+			if s == "" {
+				return 0, errors.New("nothing to parse")
+			}
+			return len(s), nil
+		},
+	}
+	doString(t, `
+		assert(parse("abc") == 3)
+		assert(select("#", parse("abc")) == 1)
+		local ok, err = pcall(parse, "")
+		assert(not ok and err:find("nothing to parse"))
+	`, globals)
+	if msg := doError(t, `parse("")`, globals); !strings.Contains(msg, "nothing to parse") {
+		t.Errorf("error %q does not mention the Go error", msg)
+	}
+}
+
+func TestNilEmbeddedPointer(t *testing.T) {
+	globals := map[string]interface{}{"o": &outer{Name: "o"}}
+	for _, src := range []string{`return o.V`, `o.V = 1`} {
+		msg := doError(t, src, globals)
+		if !strings.Contains(msg, "nil embedded pointer") || strings.Contains(msg, "reflect") {
+			t.Errorf("%s: error %q", src, msg)
+		}
+	}
+	doString(t, `assert(o.V == 2)`, map[string]interface{}{"o": &outer{inner: &inner{V: 2}}})
+}
+
+func TestEqual(t *testing.T) {
+	p, s := &point{}, []int{1}
+	doString(t, `
+		assert(a == a2)
+		assert(a ~= b)
+		assert(h1 ~= h2)
+		assert(s == s2)
+	`, map[string]interface{}{
+		"a": p, "a2": p, "b": &point{},
+		"h1": holder{A: []int{1}}, "h2": holder{A: []int{1}},
+		"s": s, "s2": s,
+	})
+}
+
+func TestBigUnsigned(t *testing.T) {
+	var big uint64 = 1<<63 + 5
+	L := doString(t, `
+		assert(small == 5 and math.type(small) == "integer")
+		assert(math.type(big) == "float" and big > 0)
+	`, map[string]interface{}{"small": uint64(5), "big": big})
+	if v := L.GetGlobal("big"); v != float64(big) {
+		t.Errorf("big = %v, want %v", v, float64(big))
+	}
+}
@@ -0,0 +1,397 @@
+// Package glua is a Lua 5.3 interpreter that can be embedded in Go
+// programs.
+//
+// Lua values are passed to Go as nil, bool, int64, float64 and string for
+// the Lua types of the same name. Go values bound with SetGlobal, Call or
+// as results of a Func are passed back as they are. Tables, functions,
+// coroutines and other userdata are handles that can only be passed back to
+// the State they came from.
+package glua
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// State is an interpreter with its own globals, libraries and call stack.
+// A State must not be used by several goroutines at once, but different
+// States share nothing and can run in parallel.
+type State struct {
+	*state
+}
+
+// state is the interpreter behind a State. The goroutines of suspended
+// coroutines only hold the state, so that a State nobody uses any more is
+// garbage collected and its finalizer can stop them.
+type state struct {
+	globals map[string]interface{}
+
+	// curPos is the position of the node being evaluated, used to report
+	// runtime errors, curChunk is the name of the chunk it belongs to.
+	curPos   Pos
+	curChunk string
+	// callStack holds the active calls, the last one is the running
+	// function.
+	callStack []callInfo
+	// mainCo stands for the main program, curCo is the running coroutine.
+	mainCo, curCo *thread
+	// threads holds the coroutines that have a goroutine, Close stops
+	// them.
+	threads map[*thread]bool
+	// dropped holds the threads of the collected coroutines, depth counts
+	// the scripts running in the State, they are closed when it gets
+	// back to 0. The finalizers run in their own goroutine, mu guards
+	// both.
+	mu      sync.Mutex
+	dropped []*thread
+	depth   int
+
+	// stringMeta is the metatable shared by all strings, its __index is the
+	// string library so that s:upper() works.
+	stringMeta *luaTable
+	// rng is the generator behind math.random, it starts with a fixed seed
+	// like the reference implementation.
+	rng *rand.Rand
+
+	fileMeta *luaTable
+	// the files io.read and io.write work on
+	defaultInput, defaultOutput *luaUserdata
+
+	// packageLib is the package table require works with.
+	packageLib *luaTable
+	// goModules holds the modules written in Go, require finds them after
+	// package.preload.
+	goModules map[string]luaFunc
+	// loading holds the modules being loaded, innermost last.
+	loading []string
+
+	// goMeta is the metatable of the Go values bound by reflection,
+	// goMethods caches their methods.
+	goMeta    *luaTable
+	goMethods map[methodKey]*luaFunc
+
+	// engine runs the chunks loaded from now on.
+	engine Engine
+}
+
+// Engine is the way a State runs chunks.
+type Engine int
+
+const (
+	// VM compiles chunks to bytecode and runs it, it is the default.
+	VM Engine = iota
+	// TreeWalker evaluates the syntax tree of chunks.
+	TreeWalker
+)
+
+// SetEngine sets the engine of the chunks loaded from now on. Functions
+// of both kinds can call each other.
+func (L *State) SetEngine(e Engine) {
+	L.engine = e
+}
+
+// NewState returns a State with the standard libraries loaded.
+func NewState() *State {
+	L := &state{
+		globals:   map[string]interface{}{},
+		mainCo:    &thread{status: "running"},
+		threads:   map[*thread]bool{},
+		rng:       rand.New(rand.NewSource(0)),
+		goModules: map[string]luaFunc{},
+	}
+	L.curCo = L.mainCo
+	L.mainCo.self = &luaCoroutine{L.mainCo}
+	L.openReflect()
+	L.openBase()
+	L.globals["math"] = L.openMath()
+	L.globals["coroutine"] = L.openCoroutine()
+	L.globals["string"] = L.openString()
+	L.globals["table"] = L.openTable()
+	L.globals["io"] = L.openIO()
+	L.globals["os"] = L.openOS()
+	L.globals["package"] = L.openPackage()
+	s := &State{L}
+	runtime.SetFinalizer(s, (*State).Close)
+	return s
+}
+
+// Close stops the goroutines of the coroutines that are suspended, they
+// would wait forever otherwise. It is called when the State is garbage
+// collected, the State must not be used after it.
+func (L *State) Close() {
+	runtime.SetFinalizer(L, nil)
+	L.mu.Lock()
+	defer L.mu.Unlock()
+	for co := range L.threads {
+		co.close()
+		delete(L.threads, co)
+	}
+}
+
+// Func is a function written in Go that scripts can call. A non-nil error
+// is raised as a Lua error.
+type Func func(args ...interface{}) ([]interface{}, error)
+
+// Error is an error raised by a script and not caught by it, or a syntax
+// error.
+type Error struct {
+	// Value is the Lua error value, usually a message starting with the
+	// position of the error.
+	Value interface{}
+	msg   string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// ExitError is returned when a script calls os.exit.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// InternalError is returned when Go code fails with a runtime error while
+// a script runs, a bug of the interpreter or of a Func. Scripts cannot catch
+// it with pcall.
+type InternalError struct {
+	Err runtime.Error
+	// Where is the "chunk:line" the script was running.
+	Where string
+}
+
+func (e *InternalError) Error() string {
+	return fmt.Sprintf("%s: internal error: %v", e.Where, e.Err)
+}
+
+// protect runs fn and returns the error it raises, the call stack is
+// unwound to where it was.
+func (L *state) protect(fn func()) (err error) {
+	n, pos, chunk := len(L.callStack), L.curPos, L.curChunk
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, ok := r.(closing); ok {
+			panic(r)
+		}
+		if c, ok := r.(exitCode); ok {
+			err = &ExitError{int(c)}
+		} else if e, ok := r.(runtime.Error); ok {
+			err = &InternalError{e, fmt.Sprintf("%s:%d", L.curChunk, L.curPos.Line)}
+		} else {
+			v := L.errorValue(r)
+			err = &Error{Value: v, msg: L.errorString(v)}
+		}
+		L.popCalls(n)
+		L.curPos, L.curChunk = pos, chunk
+	}()
+	L.busy(fn)
+	return nil
+}
+
+// DoString runs the chunk src.
+func (L *State) DoString(src string) error {
+	fn, err := L.loadChunk(strings.NewReader(src), chunkName(src), "bt")
+	if err != nil {
+		return err
+	}
+	_, err = L.Call(fn)
+	return err
+}
+
+// DoFile runs the file name. Its directory is added to package.path, so
+// that require finds the modules next to it first.
+func (L *State) DoFile(name string) error {
+	fn, err := L.loadFile(name)
+	if err != nil {
+		return err
+	}
+	L.addScriptPath(path.Dir(name))
+	_, err = L.Call(fn)
+	return err
+}
+
+// Interact runs the statements read from r one by one as soon as they are
+// complete, like the interactive interpreter. Errors are passed to report
+// and do not stop it. It returns at the end of r.
+func (L *State) Interact(r io.Reader, name string, report func(error)) {
+	defer runtime.KeepAlive(L)
+	lex := newLuaLexer(r, name)
+	top := newCallScope(nil, nil)
+	L.curChunk = name
+	lex.exec = func(s Stat) {
+		err := L.protect(func() {
+			_, top = L.execStats(&Block{Stats: []Stat{s}}, top)
+		})
+		if err != nil {
+			report(err)
+		}
+	}
+	for {
+		err := parse(lex)
+		if err == nil {
+			return
+		}
+		report(err)
+	}
+}
+
+// GetGlobal returns the value of the global variable name.
+func (L *State) GetGlobal(name string) interface{} {
+	return goValue(L.globals[name])
+}
+
+// SetGlobal sets the global variable name to v. Go integers and floats
+// become Lua numbers, a Func becomes a Lua function and other Go values
+// are bound by reflection.
+func (L *State) SetGlobal(name string, v interface{}) {
+	L.globals[name] = L.luaValue(v)
+}
+
+// RegisterFunc makes fn the global function name.
+func (L *State) RegisterFunc(name string, fn Func) {
+	L.globals[name] = goFunc(fn)
+}
+
+// RegisterModule makes require(name) return a table of the functions fns.
+func (L *State) RegisterModule(name string, fns map[string]Func) {
+	L.goModules[name] = func(L *state, args ...interface{}) []interface{} {
+		t := newTable(0, len(fns))
+		for k, fn := range fns {
+			t.set(k, goFunc(fn))
+		}
+		return []interface{}{t}
+	}
+}
+
+// Call calls the Lua function fn and returns its results.
+func (L *State) Call(fn interface{}, args ...interface{}) (rs []interface{}, err error) {
+	// the finalizer must not close L while it runs
+	defer runtime.KeepAlive(L)
+	vs := make([]interface{}, len(args))
+	for i, a := range args {
+		vs[i] = L.luaValue(a)
+	}
+	err = L.protect(func() {
+		rs = L.call(L.luaValue(fn), vs...)
+	})
+	for i := range rs {
+		rs[i] = goValue(rs[i])
+	}
+	return rs, err
+}
+
+// goFunc turns fn into a function scripts can call.
+func goFunc(fn Func) *luaFunc {
+	return newFunc(func(L *state, args ...interface{}) []interface{} {
+		for i := range args {
+			args[i] = goValue(args[i])
+		}
+		rs, err := fn(args...)
+		if err != nil {
+			raise(err)
+		}
+		for i := range rs {
+			rs[i] = L.luaValue(rs[i])
+		}
+		return rs
+	})
+}
+
+// raise raises the error err returned by Go code.
+func raise(err error) {
+	switch err := err.(type) {
+	case *Error:
+		// an error of a nested Call goes on as it is
+		panic(&luaError{err.Value})
+	case *ExitError:
+		panic(exitCode(err.Code))
+	case *InternalError:
+		panic(err.Err)
+	}
+	die("%s", err.Error())
+}
+
+// luaValue converts a Go value to the value scripts see.
+func (L *state) luaValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string,
+		*luaTable, *luaFunc, *luaClosure, *luaCoroutine, *luaUserdata:
+		return v
+	case Func:
+		return goFunc(v)
+	case func(...interface{}) ([]interface{}, error):
+		return goFunc(v)
+	}
+	return L.bind(reflect.ValueOf(v))
+}
+
+// chunkName is the name of the chunk src in messages, like the reference
+// implementation it shows the start of the source.
+func chunkName(src string) string {
+	const maxLen = 40
+	line, more := src, false
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		line, more = src[:i], true
+	}
+	if len(line) > maxLen {
+		line, more = line[:maxLen], true
+	}
+	if more {
+		line += "..."
+	}
+	return fmt.Sprintf("[string \"%s\"]", line)
+}
+
+// loadName is the name in messages of a chunk that load is given the name
+// of: "=name" and "@name" stand for name itself, others are shown like the
+// start of a source.
+func loadName(name string) string {
+	if strings.HasPrefix(name, "=") || strings.HasPrefix(name, "@") {
+		return name[1:]
+	}
+	return chunkName(name)
+}
+
+// CompileFile compiles the source file name and writes it to w as a
+// binary chunk, which DoFile, load and require run like the source. strip
+// leaves out the names and lines used in error messages.
+func CompileFile(name string, w io.Writer, strip bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fn, err := parseChunk(f, name)
+	if err != nil {
+		return err
+	}
+	p, err := compile(fn)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(dump(p, strip))
+	return err
+}
+
+// loadFile is loadChunk for the file name.
+func (L *state) loadFile(name string) (*luaClosure, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return L.loadChunk(f, name, "bt")
+}
@@ -0,0 +1,271 @@
+package glua
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDoString(t *testing.T) {
+	L := NewState()
+	if err := L.DoString(`x = 1 + 2`); err != nil {
+		t.Fatal(err)
+	}
+	if x := L.GetGlobal("x"); x != int64(3) {
+		t.Errorf("x = %v, want 3", x)
+	}
+
+	err := L.DoString(`local t = nil; return t.x`)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got %T %v, want *Error", err, err)
+	}
+	if want := `[string "local t = nil; return t.x"]:1: attempt to index a nil value (local 't')`; e.Error() != want {
+		t.Errorf("error %q, want %q", e.Error(), want)
+	}
+	if e.Value != e.Error() {
+		t.Errorf("value %v, want the message", e.Value)
+	}
+
+	err = L.DoString(`error({code = 7})`)
+	if e, ok := err.(*Error); !ok || L.errorString(e.Value) != "(error object is a table value)" {
+		t.Errorf("got %v, want a table error value", err)
+	}
+
+	err = L.DoString(`x = = 1`)
+	if e, ok := err.(*Error); !ok || !strings.HasPrefix(e.Error(), `[string "x = = 1"]:1:`) {
+		t.Errorf("got %v, want a syntax error", err)
+	}
+}
+
+func TestExitError(t *testing.T) {
+	L := NewState()
+	err := L.DoString(`pcall(os.exit, 3) error("not reached")`)
+	if e, ok := err.(*ExitError); !ok || e.Code != 3 {
+		t.Fatalf("got %v, want exit status 3", err)
+	}
+	if err := L.DoString(`y = 1`); err != nil {
+		t.Errorf("the State does not work after os.exit: %v", err)
+	}
+}
+
+func TestDoFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.lua", `local m = require("mod") result = m.twice(21)`)
+	write("mod.lua", `return {twice = function(x) return x * 2 end}`)
+	write("bad.lua", "x = 1\nerror('boom')\n")
+
+	L := NewState()
+	if err := L.DoFile(filepath.Join(dir, "main.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if r := L.GetGlobal("result"); r != int64(42) {
+		t.Errorf("result = %v, want 42", r)
+	}
+	bad := filepath.Join(dir, "bad.lua")
+	if err := L.DoFile(bad); err == nil || err.Error() != bad+":2: boom" {
+		t.Errorf("got %v, want %s:2: boom", err, bad)
+	}
+	if err := L.DoFile(filepath.Join(dir, "missing.lua")); !os.IsNotExist(err) {
+		t.Errorf("got %v, want a missing file", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	L := NewState()
+	L.SetGlobal("i", 7)
+	L.SetGlobal("f", float32(1.5))
+	L.SetGlobal("s", "str")
+	L.SetGlobal("b", true)
+	if err := L.DoString(`
+		assert(math.type(i) == "integer" and i == 7)
+		assert(math.type(f) == "float" and f == 1.5)
+		assert(s == "str" and b == true)
+		t = {1, 2}
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if v := L.GetGlobal("nothing"); v != nil {
+		t.Errorf("nothing = %v, want nil", v)
+	}
+	// a table comes back as a handle that can be passed to the State again
+	tab := L.GetGlobal("t")
+	L.SetGlobal("t2", tab)
+	if err := L.DoString(`assert(t2 == t and t2[2] == 2)`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	L := NewState()
+	L.RegisterFunc("add", func(args ...interface{}) ([]interface{}, error) {
+		a, _ := args[0].(int64)
+		b, _ := args[1].(int64)
+		return []interface{}{a + b, "sum"}, nil
+	})
+	L.RegisterFunc("fail", func(args ...interface{}) ([]interface{}, error) {
+		return nil, errors.New("it failed")
+	})
+	if err := L.DoString(`
+		local n, s = add(1, 2)
+		assert(n == 3 and s == "sum")
+		local ok, err = pcall(fail)
+		assert(not ok and err == "it failed")
+	`); err != nil {
+		t.Fatal(err)
+	}
+	want := `[string "fail()"]:1: it failed`
+	if err := L.DoString(`fail()`); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	L := NewState()
+	L.RegisterFunc("bug", func(args ...interface{}) ([]interface{}, error) {
+		return []interface{}{args[5]}, nil
+	})
+	err := L.DoString("x = 1\nlocal ok = pcall(bug)\nx = 2")
+	e, ok := err.(*InternalError)
+	if !ok {
+		t.Fatalf("got %T %v, want *InternalError", err, err)
+	}
+	if e.Where != `[string "x = 1..."]:2` || !strings.Contains(e.Error(), "index out of range") {
+		t.Errorf("error %q", e.Error())
+	}
+	if x := L.GetGlobal("x"); x != int64(1) {
+		t.Errorf("pcall caught the runtime error, x = %v", x)
+	}
+	if err := L.DoString(`x = 3`); err != nil {
+		t.Errorf("the State does not work after an internal error: %v", err)
+	}
+	err = L.DoString(`coroutine.wrap(function() pcall(bug) end)()`)
+	if _, ok := err.(*InternalError); !ok {
+		t.Errorf("got %T %v from a coroutine, want *InternalError", err, err)
+	}
+}
+
+func TestRegisterModule(t *testing.T) {
+	L := NewState()
+	calls := 0
+	L.RegisterModule("counter", map[string]Func{
+		"inc": func(args ...interface{}) ([]interface{}, error) {
+			calls++
+			return []interface{}{calls}, nil
+		},
+	})
+	if err := L.DoString(`
+		local c = require("counter")
+		assert(c.inc() == 1)
+		assert(require("counter") == c)
+		assert(package.loaded.counter == c)
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`require("nosuchmodule")`); err == nil {
+		t.Error("require of a missing module did not fail")
+	}
+}
+
+func TestCall(t *testing.T) {
+	L := NewState()
+	if err := L.DoString(`
+		function swap(a, b) return b, a end
+		function fail(msg) error(msg, 0) end
+	`); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := L.Call(L.GetGlobal("swap"), 1, "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0] != "two" || rs[1] != int64(1) {
+		t.Errorf("swap(1, \"two\") = %v, want [two 1]", rs)
+	}
+	if _, err := L.Call(L.GetGlobal("fail"), "oops"); err == nil || err.Error() != "oops" {
+		t.Errorf("got %v, want oops", err)
+	}
+	if _, err := L.Call(nil); err == nil {
+		t.Error("calling nil did not fail")
+	}
+	// the call stack is back where it was after an error
+	if err := L.DoString(`assert(select("#", swap(1, 2)) == 2)`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEngines(t *testing.T) {
+	for _, e := range []Engine{VM, TreeWalker} {
+		L := NewState()
+		L.SetEngine(e)
+		if err := L.DoString(`local s = 0 for i = 1, 10 do s = s + i end r = s`); err != nil {
+			t.Fatal(err)
+		}
+		if r := L.GetGlobal("r"); r != int64(55) {
+			t.Errorf("engine %d: r = %v, want 55", e, r)
+		}
+	}
+}
+
+func TestInteract(t *testing.T) {
+	L := NewState()
+	var errs []error
+	L.Interact(strings.NewReader("local x = 1\ny = x + 1\nerror('e')\nz = y\n"), "stdin",
+		func(err error) { errs = append(errs, err) })
+	if z := L.GetGlobal("z"); z != int64(2) {
+		t.Errorf("z = %v, want 2", z)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "e") {
+		t.Errorf("errors %v, want one", errs)
+	}
+}
+
+// States share nothing and can run in parallel, go test -race checks it.
+func TestIsolatedStates(t *testing.T) {
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			L := NewState()
+			L.SetGlobal("id", i)
+			L.RegisterFunc("get", func(args ...interface{}) ([]interface{}, error) {
+				return []interface{}{i}, nil
+			})
+			errs[i] = L.DoString(`
+				assert(shared == nil)
+				shared = id
+				string.mine = id
+				local co = coroutine.wrap(function()
+					for j = 1, 100 do coroutine.yield(j) end
+				end)
+				local s = 0
+				for j = 1, 100 do s = s + co() end
+				assert(s == 5050)
+				local t = {}
+				for j = 1, 1000 do t[j] = tostring(j):rep(2) end
+				table.sort(t)
+				assert(shared == id and get() == id and string.mine == id)
+			`)
+			if errs[i] == nil && L.GetGlobal("shared") != int64(i) {
+				errs[i] = fmt.Errorf("shared = %v, want %d", L.GetGlobal("shared"), i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("State %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package glua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// strIndex converts the Lua string position i, which may be negative, to
+// a position in 0..n.
+func strIndex(i int64, n int) int64 {
+	if i < 0 {
+		i += int64(n) + 1
+		if i < 0 {
+			i = 0
+		}
+	}
+	return i
+}
+
+// strRange converts the Lua positions i and j of a string of length n to
+// a slice range.
+func strRange(i, j int64, n int) (int, int) {
+	i, j = strIndex(i, n), strIndex(j, n)
+	if i < 1 {
+		i = 1
+	}
+	if j > int64(n) {
+		j = int64(n)
+	}
+	if i > j {
+		return 0, 0
+	}
+	return int(i - 1), int(j)
+}
+
+// openString builds the string library and the string metatable.
+func (L *state) openString() *luaTable {
+	lib := map[string]luaFunc{
+		"len": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{int64(len(checkString(args, 1, "len")))}
+		},
+		"sub": func(L *state, args ...interface{}) []interface{} {
+			s := checkString(args, 1, "sub")
+			i, j := strRange(optInteger(args, 2, "sub", 1), optInteger(args, 3, "sub", -1), len(s))
+			return []interface{}{s[i:j]}
+		},
+		"upper": func(L *state, args ...interface{}) []interface{} {
+			b := []byte(checkString(args, 1, "upper"))
+			for i, c := range b {
+				if 'a' <= c && c <= 'z' {
+					b[i] = c - 'a' + 'A'
+				}
+			}
+			return []interface{}{string(b)}
+		},
+		"lower": func(L *state, args ...interface{}) []interface{} {
+			b := []byte(checkString(args, 1, "lower"))
+			for i, c := range b {
+				if 'A' <= c && c <= 'Z' {
+					b[i] = c - 'A' + 'a'
+				}
+			}
+			return []interface{}{string(b)}
+		},
+		"rep": func(L *state, args ...interface{}) []interface{} {
+			s := checkString(args, 1, "rep")
+			n := checkInteger(args, 2, "rep")
+			sep := ""
+			if argAt(args, 2) != nil {
+				sep = checkString(args, 3, "rep")
+			}
+			if n <= 0 {
+				return []interface{}{""}
+			}
+			if int64(len(s)+len(sep))*n > math.MaxInt32 {
+				die("resulting string too large")
+			}
+			if sep == "" {
+				return []interface{}{strings.Repeat(s, int(n))}
+			}
+			return []interface{}{strings.Repeat(s+sep, int(n)-1) + s}
+		},
+		"reverse": func(L *state, args ...interface{}) []interface{} {
+			b := []byte(checkString(args, 1, "reverse"))
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return []interface{}{string(b)}
+		},
+		"byte": func(L *state, args ...interface{}) []interface{} {
+			s := checkString(args, 1, "byte")
+			pos := optInteger(args, 2, "byte", 1)
+			i, j := strRange(pos, optInteger(args, 3, "byte", pos), len(s))
+			var rs []interface{}
+			for _, c := range []byte(s[i:j]) {
+				rs = append(rs, int64(c))
+			}
+			return rs
+		},
+		"char": func(L *state, args ...interface{}) []interface{} {
+			b := make([]byte, len(args))
+			for i := range args {
+				c := checkInteger(args, i+1, "char")
+				if c < 0 || c > 255 {
+					die("bad argument #%d to 'char' (value out of range)", i+1)
+				}
+				b[i] = byte(c)
+			}
+			return []interface{}{string(b)}
+		},
+		"format": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{L.format(checkString(args, 1, "format"), args)}
+		},
+		"find": func(L *state, args ...interface{}) []interface{} {
+			return strFind(args, "find", true)
+		},
+		"match": func(L *state, args ...interface{}) []interface{} {
+			return strFind(args, "match", false)
+		},
+		"gmatch": func(L *state, args ...interface{}) []interface{} {
+			return []interface{}{gmatch(checkString(args, 1, "gmatch"), checkString(args, 2, "gmatch"))}
+		},
+		"gsub": func(L *state, args ...interface{}) []interface{} {
+			return L.gsub(args)
+		},
+		"dump": func(L *state, args ...interface{}) []interface{} {
+			c, ok := argAt(args, 0).(*luaClosure)
+			if !ok {
+				if _, ok := argAt(args, 0).(*luaFunc); !ok {
+					die("bad argument #1 to 'dump' (function expected, got %s)", argType(args, 0))
+				}
+			}
+			// only compiled functions have a binary form
+			if c == nil || c.p == nil {
+				die("unable to dump given function")
+			}
+			return []interface{}{string(dump(c.p, truthy(argAt(args, 1))))}
+		},
+	}
+	t := newTable(0, len(lib))
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	L.stringMeta = newTable(0, 1)
+	L.stringMeta.set("__index", t)
+	return t
+}
+
+// format implements string.format, args[0] is the format string.
+func (L *state) format(f string, args []interface{}) string {
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			continue
+		}
+		i++
+		if i < len(f) && f[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		// flags, width and precision are passed on to fmt
+		start := i
+		for i < len(f) && strings.IndexByte("-+ #0", f[i]) >= 0 {
+			i++
+		}
+		for k := 0; k < 2 && i < len(f) && '0' <= f[i] && f[i] <= '9'; k++ {
+			i++
+		}
+		if i < len(f) && f[i] == '.' {
+			i++
+			for k := 0; k < 2 && i < len(f) && '0' <= f[i] && f[i] <= '9'; k++ {
+				i++
+			}
+		}
+		if i >= len(f) {
+			die("invalid conversion '%%%s' to 'format'", f[start:])
+		}
+		spec := f[start:i]
+		conv := f[i]
+		if strings.IndexByte("cdiouxXaAeEfFgGqs", conv) < 0 {
+			die("invalid conversion '%%%s' to 'format'", f[start:i+1])
+		}
+		n++
+		if n > len(args) {
+			die("bad argument #%d to 'format' (no value)", n)
+		}
+		switch conv {
+		case 'c':
+			b.WriteByte(byte(checkInteger(args, n, "format")))
+		case 'd', 'i':
+			fmt.Fprintf(&b, "%"+spec+"d", checkInteger(args, n, "format"))
+		case 'o', 'u', 'x', 'X':
+			// C prints these as unsigned
+			v := uint64(checkInteger(args, n, "format"))
+			if conv == 'u' {
+				conv = 'd'
+			}
+			fmt.Fprintf(&b, "%"+spec+string(conv), v)
+		case 'a', 'A':
+			b.WriteString(hexFloat(checkNumber(args, n, "format"), spec, conv == 'A'))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			v := checkNumber(args, n, "format")
+			if !strings.Contains(spec, ".") {
+				// C defaults to a precision of 6, fmt to the shortest
+				spec += ".6"
+			}
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				s := numToStr(v)
+				if strings.IndexByte("EFG", conv) >= 0 {
+					s = strings.ToUpper(s)
+				}
+				if v > 0 && strings.Contains(spec, "+") {
+					s = "+" + s
+				}
+				fmt.Fprintf(&b, "%"+strings.Split(strings.TrimLeft(spec, "0+ #"), ".")[0]+"s", s)
+				continue
+			}
+			if conv == 'F' {
+				conv = 'f'
+			}
+			fmt.Fprintf(&b, "%"+spec+string(conv), v)
+		case 'q':
+			b.WriteString(L.quoteValue(args[n-1]))
+		case 's':
+			fmt.Fprintf(&b, "%"+spec+"s", L.tostr(args[n-1]))
+		}
+	}
+	return b.String()
+}
+
+// checkNumber returns the n-th argument of the builtin fname as a float.
+func checkNumber(args []interface{}, n int, fname string) float64 {
+	f, ok := toFloat(coerce(argAt(args, n-1)))
+	if !ok {
+		die("bad argument #%d to '%s' (number expected, got %s)", n, fname, argType(args, n-1))
+	}
+	return f
+}
+
+// hexFloat formats f like C's %a.
+func hexFloat(f float64, spec string, upper bool) string {
+	prec := -1
+	if i := strings.IndexByte(spec, '.'); i >= 0 {
+		prec, _ = strconv.Atoi(spec[i+1:])
+		spec = spec[:i]
+	}
+	s := strconv.FormatFloat(f, 'x', prec, 64)
+	// fmt writes at least two exponent digits, C as few as possible
+	if i := strings.IndexAny(s, "+-"); i > 0 {
+		exp := strings.TrimLeft(s[i+1:], "0")
+		if exp == "" {
+			exp = "0"
+		}
+		s = s[:i+1] + exp
+	}
+	if upper {
+		s = strings.ToUpper(s)
+	}
+	return fmt.Sprintf("%"+strings.TrimLeft(spec, "0")+"s", s)
+}
+
+// quoteValue formats a value for %q so that Lua can read it back.
+func (L *state) quoteValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		var b strings.Builder
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			switch {
+			case c == '"' || c == '\\' || c == '\n':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c < 32 || c == 127:
+				if i+1 < len(v) && '0' <= v[i+1] && v[i+1] <= '9' {
+					fmt.Fprintf(&b, "\\%03d", c)
+				} else {
+					fmt.Fprintf(&b, "\\%d", c)
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+		return b.String()
+	case int64:
+		if v == math.MinInt64 {
+			return "0x8000000000000000"
+		}
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e9999"
+		case math.IsInf(v, -1):
+			return "-1e9999"
+		case math.IsNaN(v):
+			return "(0/0)"
+		}
+		// hexadecimal floats are exact and keep the float subtype
+		return hexFloat(v, "", false)
+	case nil, bool:
+		return L.tostr(v)
+	}
+	die("bad argument to 'format' (value has no literal form)")
+	return ""
+}
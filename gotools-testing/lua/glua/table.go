@@ -0,0 +1,203 @@
+package glua
+
+import (
+	"math"
+)
+
+// luaTable is an associative array. Positive integer keys that are dense
+// from 1 live in the array part, every other key lives in the hash part.
+// Hash keys keep the order they were first set in so that next can walk
+// the table while fields are cleared.
+type luaTable struct {
+	arr   []interface{}
+	slots []slot
+	index map[interface{}]int
+	dead  int
+	meta  *luaTable
+}
+
+type slot struct {
+	k, v interface{}
+}
+
+func newTable(narr, nhash int) *luaTable {
+	return &luaTable{
+		arr:   make([]interface{}, 0, narr),
+		index: make(map[interface{}]int, nhash),
+	}
+}
+
+// normKey converts float keys with an integer value to integers, so that
+// t[1] and t[1.0] are the same field.
+func normKey(k interface{}) interface{} {
+	if f, ok := k.(float64); ok {
+		if n, ok := floatToInteger(f); ok {
+			return n
+		}
+	}
+	return k
+}
+
+// arrayIndex returns the position of k in the array part, or -1.
+func (t *luaTable) arrayIndex(k interface{}) int {
+	if n, ok := k.(int64); ok && n >= 1 && n <= int64(len(t.arr)) {
+		return int(n - 1)
+	}
+	return -1
+}
+
+func (t *luaTable) get(k interface{}) interface{} {
+	k = normKey(k)
+	if i := t.arrayIndex(k); i >= 0 {
+		return t.arr[i]
+	}
+	if i, ok := t.index[k]; ok {
+		return t.slots[i].v
+	}
+	return nil
+}
+
+func (t *luaTable) set(k, v interface{}) {
+	k = normKey(k)
+	switch n := k.(type) {
+	case nil:
+		die("table index is nil")
+	case float64:
+		if math.IsNaN(n) {
+			die("table index is NaN")
+		}
+	case int64:
+		if i := t.arrayIndex(k); i >= 0 {
+			t.arr[i] = v
+			return
+		}
+		if n == int64(len(t.arr)+1) && v != nil {
+			t.hashSet(k, nil)
+			t.arr = append(t.arr, v)
+			t.migrate()
+			return
+		}
+	}
+	t.hashSet(k, v)
+}
+
+// migrate moves the keys following the array part out of the hash part.
+func (t *luaTable) migrate() {
+	for {
+		k := int64(len(t.arr) + 1)
+		i, ok := t.index[k]
+		if !ok || t.slots[i].v == nil {
+			return
+		}
+		v := t.slots[i].v
+		t.hashSet(k, nil)
+		t.arr = append(t.arr, v)
+	}
+}
+
+func (t *luaTable) hashSet(k, v interface{}) {
+	if i, ok := t.index[k]; ok {
+		if t.slots[i].v == nil && v != nil {
+			t.dead--
+		} else if t.slots[i].v != nil && v == nil {
+			t.dead++
+		}
+		t.slots[i].v = v
+		return
+	}
+	if v == nil {
+		return
+	}
+	if t.dead > len(t.slots)/2 {
+		t.compact()
+	}
+	t.index[k] = len(t.slots)
+	t.slots = append(t.slots, slot{k, v})
+}
+
+// compact drops the cleared slots, it must not run during a traversal.
+func (t *luaTable) compact() {
+	slots := make([]slot, 0, len(t.slots)-t.dead)
+	for _, s := range t.slots {
+		if s.v == nil {
+			delete(t.index, s.k)
+			continue
+		}
+		t.index[s.k] = len(slots)
+		slots = append(slots, s)
+	}
+	t.slots = slots
+	t.dead = 0
+}
+
+// next returns the field after k, k == nil starts the traversal.
+// The returned key is nil at the end of the table.
+func (t *luaTable) next(k interface{}) (interface{}, interface{}) {
+	i := 0
+	k = normKey(k)
+	if k != nil {
+		if j := t.arrayIndex(k); j >= 0 {
+			i = j + 1
+		} else if j, ok := t.index[k]; ok {
+			i = len(t.arr) + j + 1
+		} else {
+			die("invalid key to 'next'")
+		}
+	}
+	for ; i < len(t.arr); i++ {
+		if v := t.arr[i]; v != nil {
+			return int64(i + 1), v
+		}
+	}
+	for i -= len(t.arr); i < len(t.slots); i++ {
+		if s := t.slots[i]; s.v != nil {
+			return s.k, s.v
+		}
+	}
+	return nil, nil
+}
+
+// length returns a border of the table: an index n where t[n] is not nil
+// and t[n+1] is nil, or 0 if t[1] is nil.
+func (t *luaTable) length() int {
+	n := len(t.arr)
+	if n > 0 && t.arr[n-1] == nil {
+		// binary search for a border inside the array part
+		i, j := 0, n
+		for j-i > 1 {
+			m := (i + j) / 2
+			if t.arr[m-1] == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	if len(t.slots) == t.dead {
+		return n
+	}
+	// unbound search in the hash part
+	i, j := n, n+1
+	for t.get(int64(j)) != nil {
+		i = j
+		if j > math.MaxInt32 {
+			// pathological table, fall back to a linear search
+			for k := 1; ; k++ {
+				if t.get(int64(k)) == nil {
+					return k - 1
+				}
+			}
+		}
+		j *= 2
+	}
+	for j-i > 1 {
+		m := (i + j) / 2
+		if t.get(int64(m)) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
+}
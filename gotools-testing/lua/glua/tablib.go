@@ -0,0 +1,265 @@
+package glua
+
+import (
+	"math"
+	"strings"
+)
+
+// maxResults limits the values a builtin can return at once, like the
+// stack limit of the reference implementation.
+const maxResults = 1000000
+
+// openTable builds the table library. Like the reference implementation it
+// goes through opIndex, opSetIndex and opLen, so it respects metamethods
+// and sees the same border as the # operator.
+func (L *state) openTable() *luaTable {
+	lib := map[string]luaFunc{
+		"insert": func(L *state, args ...interface{}) []interface{} {
+			t := L.checkTab(args, 1, "insert")
+			e := L.tabLen(t) + 1
+			switch len(args) {
+			case 2:
+				L.opSetIndex(t, e, args[1])
+			case 3:
+				pos := checkInteger(args, 2, "insert")
+				// unsigned comparison also rejects pos < 1
+				if uint64(pos)-1 >= uint64(e) {
+					die("bad argument #2 to 'insert' (position out of bounds)")
+				}
+				for i := e; i > pos; i-- {
+					L.opSetIndex(t, i, L.opIndex(t, i-1))
+				}
+				L.opSetIndex(t, pos, args[2])
+			default:
+				die("wrong number of arguments to 'insert'")
+			}
+			return nil
+		},
+		"remove": func(L *state, args ...interface{}) []interface{} {
+			t := L.checkTab(args, 1, "remove")
+			size := L.tabLen(t)
+			pos := optInteger(args, 2, "remove", size)
+			if pos != size && uint64(pos)-1 > uint64(size) {
+				die("bad argument #1 to 'remove' (position out of bounds)")
+			}
+			v := L.opIndex(t, pos)
+			for ; pos < size; pos++ {
+				L.opSetIndex(t, pos, L.opIndex(t, pos+1))
+			}
+			L.opSetIndex(t, pos, nil)
+			return []interface{}{v}
+		},
+		"concat": func(L *state, args ...interface{}) []interface{} {
+			t := L.checkTab(args, 1, "concat")
+			sep := ""
+			if argAt(args, 1) != nil {
+				sep = checkString(args, 2, "concat")
+			}
+			i := optInteger(args, 3, "concat", 1)
+			var j int64
+			if argAt(args, 3) == nil {
+				j = L.tabLen(t)
+			} else {
+				j = checkInteger(args, 4, "concat")
+			}
+			var b strings.Builder
+			for ; i <= j; i++ {
+				s, ok := concatString(L.opIndex(t, i))
+				if !ok {
+					die("invalid value (at index %d) in table for 'concat'", i)
+				}
+				b.WriteString(s)
+				if i == j {
+					break
+				}
+				b.WriteString(sep)
+			}
+			return []interface{}{b.String()}
+		},
+		"pack": func(L *state, args ...interface{}) []interface{} {
+			t := newTable(len(args), 1)
+			for i, a := range args {
+				t.set(int64(i+1), a)
+			}
+			t.set("n", int64(len(args)))
+			return []interface{}{t}
+		},
+		"unpack": func(L *state, args ...interface{}) []interface{} {
+			t := argAt(args, 0)
+			i := optInteger(args, 2, "unpack", 1)
+			var j int64
+			if argAt(args, 2) == nil {
+				j = L.tabLen(t)
+			} else {
+				j = checkInteger(args, 3, "unpack")
+			}
+			if i > j {
+				return nil
+			}
+			if uint64(j)-uint64(i) >= maxResults {
+				die("too many results to unpack")
+			}
+			rs := make([]interface{}, 0, j-i+1)
+			for ; ; i++ {
+				rs = append(rs, L.opIndex(t, i))
+				if i == j {
+					return rs
+				}
+			}
+		},
+		"move": func(L *state, args ...interface{}) []interface{} {
+			a1 := L.checkTab(args, 1, "move")
+			f := checkInteger(args, 2, "move")
+			e := checkInteger(args, 3, "move")
+			tpos := checkInteger(args, 4, "move")
+			a2 := a1
+			if argAt(args, 4) != nil {
+				a2 = L.checkTab(args, 5, "move")
+			}
+			if e >= f {
+				if f <= 0 && e >= math.MaxInt64+f {
+					die("bad argument #3 to 'move' (too many elements to move)")
+				}
+				n := e - f
+				if tpos > math.MaxInt64-n {
+					die("bad argument #4 to 'move' (destination wrap around)")
+				}
+				if tpos > e || tpos <= f || a1 != a2 {
+					for i := int64(0); i <= n; i++ {
+						L.opSetIndex(a2, tpos+i, L.opIndex(a1, f+i))
+					}
+				} else {
+					for i := n; i >= 0; i-- {
+						L.opSetIndex(a2, tpos+i, L.opIndex(a1, f+i))
+					}
+				}
+			}
+			return []interface{}{a2}
+		},
+		"sort": func(L *state, args ...interface{}) []interface{} {
+			t := L.checkTab(args, 1, "sort")
+			n := L.tabLen(t)
+			if n > math.MaxInt32 {
+				die("bad argument #1 to 'sort' (array too big)")
+			}
+			var cmp interface{}
+			if argAt(args, 1) != nil {
+				if !callable(args[1]) {
+					die("bad argument #2 to 'sort' (function expected, got %s)", argType(args, 1))
+				}
+				cmp = args[1]
+			}
+			a := make([]interface{}, n)
+			for i := range a {
+				a[i] = L.opIndex(t, int64(i+1))
+			}
+			s := sorter{L, a, cmp}
+			s.sort(0, len(a)-1)
+			for i, v := range a {
+				L.opSetIndex(t, int64(i+1), v)
+			}
+			return nil
+		},
+	}
+	t := newTable(0, len(lib))
+	for name, fn := range lib {
+		t.set(name, newFunc(fn))
+	}
+	return t
+}
+
+// checkTab returns the n-th argument of the builtin fname, which must be a
+// table or have a metatable that can stand in for one.
+func (L *state) checkTab(args []interface{}, n int, fname string) interface{} {
+	a := argAt(args, n-1)
+	if _, ok := a.(*luaTable); ok {
+		return a
+	}
+	if mt := L.getMeta(a); mt != nil && (mt.get("__index") != nil || mt.get("__newindex") != nil || mt.get("__len") != nil) {
+		return a
+	}
+	die("bad argument #%d to '%s' (table expected, got %s)", n, fname, argType(args, n-1))
+	return nil
+}
+
+// tabLen is the length of t as the # operator sees it.
+func (L *state) tabLen(t interface{}) int64 {
+	n, ok := L.opLen(t).(int64)
+	if !ok {
+		die("object length is not an integer")
+	}
+	return n
+}
+
+// sorter is the quicksort of table.sort. It follows the reference
+// implementation, which notices an inconsistent comparison function when a
+// scan runs past the pivot.
+type sorter struct {
+	L   *state
+	a   []interface{}
+	cmp interface{}
+}
+
+func (s *sorter) less(a, b interface{}) bool {
+	if s.cmp == nil {
+		return s.L.opLT(a, b)
+	}
+	return truthy(first(s.L.call(s.cmp, a, b)))
+}
+
+func (s *sorter) sort(lo, up int) {
+	a := s.a
+	for lo < up {
+		if s.less(a[up], a[lo]) {
+			a[lo], a[up] = a[up], a[lo]
+		}
+		if up-lo == 1 {
+			return
+		}
+		p := lo + (up-lo)/2
+		if s.less(a[p], a[lo]) {
+			a[p], a[lo] = a[lo], a[p]
+		} else if s.less(a[up], a[p]) {
+			a[p], a[up] = a[up], a[p]
+		}
+		if up-lo == 2 {
+			return
+		}
+		// a[lo] <= a[p] <= a[up], keep the pivot next to the end
+		a[p], a[up-1] = a[up-1], a[p]
+		p = s.partition(lo, up)
+		// recurse into the smaller half
+		if p-lo < up-p {
+			s.sort(lo, p-1)
+			lo = p + 1
+		} else {
+			s.sort(p+1, up)
+			up = p - 1
+		}
+	}
+}
+
+// partition splits a[lo..up] around the pivot a[up-1] and returns its new
+// position.
+func (s *sorter) partition(lo, up int) int {
+	a := s.a
+	pivot := a[up-1]
+	i, j := lo, up-1
+	for {
+		for i++; s.less(a[i], pivot); i++ {
+			if i == up-1 {
+				die("invalid order function for sorting")
+			}
+		}
+		for j--; s.less(pivot, a[j]); j-- {
+			if j < i {
+				die("invalid order function for sorting")
+			}
+		}
+		if j < i {
+			a[up-1], a[i] = a[i], a[up-1]
+			return i
+		}
+		a[i], a[j] = a[j], a[i]
+	}
+}
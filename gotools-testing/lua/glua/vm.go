@@ -0,0 +1,454 @@
+package glua
+
+// upval is a variable captured by a closure. It points to the register of
+// the variable while its block runs, then to its own copy.
+type upval struct {
+	p   *interface{}
+	v   interface{}
+	reg int
+}
+
+// forState is the state of a numeric for loop, kept in its first register.
+// An integer loop counts its iterations first so that i never overflows.
+type forState struct {
+	float   bool
+	i, step int64
+	count   uint64
+
+	f, fstep, flimit float64
+}
+
+var forErrors = [...]string{
+	"'for' initial value must be a number",
+	"'for' limit must be a number",
+	"'for' step must be a number",
+}
+
+// rk returns the operand x of an instruction, a register or a constant.
+func rk(regs, k []interface{}, x int) interface{} {
+	if x&bitRK != 0 {
+		return k[x&^bitRK]
+	}
+	return regs[x]
+}
+
+// operator runs the arithmetic, bitwise, concatenation or length
+// instruction op at pc of p on its operands x and y, for the cases that
+// execute does not do itself. Its error names the variable of the bad
+// operand.
+func (L *state) operator(op opcode, x, y interface{}, p *proto, pc int) interface{} {
+	defer func() {
+		if r := recover(); r != nil {
+			info := p.info[pc]
+			panic(nameOperand(r, info[0], info[1]))
+		}
+	}()
+	switch op {
+	case opADD:
+		return L.opAdd(x, y)
+	case opSUB:
+		return L.opMinus(x, y)
+	case opMUL:
+		return L.opMultiply(x, y)
+	case opMOD:
+		return L.opMod(x, y)
+	case opPOW:
+		return L.opPow(x, y)
+	case opDIV:
+		return L.opDevide(x, y)
+	case opIDIV:
+		return L.opIdiv(x, y)
+	case opBAND:
+		return L.opBand(x, y)
+	case opBOR:
+		return L.opBor(x, y)
+	case opBXOR:
+		return L.opBxor(x, y)
+	case opSHL:
+		return L.opShl(x, y)
+	case opSHR:
+		return L.opShr(x, y)
+	case opCONCAT:
+		return L.opStrAppend(x, y)
+	case opUNM:
+		return L.opNegative(x)
+	case opBNOT:
+		return L.opBnot(x)
+	case opLEN:
+		return L.opLen(x)
+	}
+	panic("unknown operator")
+}
+
+// badCode is raised for an instruction whose registers do not hold what
+// the compiler put there for it, which only a binary chunk that was
+// tampered with past verify can do.
+func badCode() {
+	die("corrupted precompiled chunk")
+}
+
+// execute runs the compiled closure cl. Every call has its own registers,
+// so that closures can keep pointing to them once it has returned.
+func (L *state) execute(cl *luaClosure, args []interface{}) []interface{} {
+	p := cl.p
+	L.curChunk = p.source
+	regs := make([]interface{}, p.maxStack)
+	copy(regs[:p.numParams], args)
+	var varargs []interface{}
+	if p.vararg && len(args) > p.numParams {
+		varargs = args[p.numParams:]
+	}
+	code, k, lines := p.code, p.consts, p.lines
+
+	// open holds the upvalues that still point to regs
+	var open []*upval
+	// mret holds the values of the last call or ... that kept all of
+	// them, they follow the register mbase
+	var mret []interface{}
+	mbase := 0
+	// pending holds the items of the table constructors being built, by
+	// register, until their last SETLIST
+	var pending map[int][]interface{}
+
+	for pc := 0; ; {
+		i := code[pc]
+		L.curPos = Pos{Line: int(lines[pc])}
+		pc++
+		a := i.a()
+		switch i.op() {
+		case opMOVE:
+			regs[a] = regs[i.b()]
+		case opLOADK:
+			regs[a] = k[i.bx()]
+		case opLOADBOOL:
+			regs[a] = i.b() != 0
+			if i.c() != 0 {
+				pc++
+			}
+		case opLOADNIL:
+			for j := a; j <= a+i.b(); j++ {
+				regs[j] = nil
+			}
+		case opGETUPVAL:
+			regs[a] = *cl.upvals[i.b()].p
+		case opGETGLOBAL:
+			regs[a] = L.globals[k[i.bx()].(string)]
+		case opGETTABLE:
+			obj, key := regs[i.b()], rk(regs, k, i.c())
+			if t, ok := obj.(*luaTable); ok {
+				if v := t.get(key); v != nil || t.meta == nil {
+					regs[a] = v
+					continue
+				}
+			} else if L.metaOf(obj, "__index") == nil {
+				die("attempt to index a %s value%s", valType(obj), p.info[pc-1][0])
+			}
+			regs[a] = L.opIndex(obj, key)
+		case opSETGLOBAL:
+			L.globals[k[i.bx()].(string)] = regs[a]
+		case opSETUPVAL:
+			*cl.upvals[i.b()].p = regs[a]
+		case opSETTABLE:
+			obj, key, v := regs[a], rk(regs, k, i.b()), rk(regs, k, i.c())
+			if t, ok := obj.(*luaTable); ok {
+				if t.meta == nil {
+					t.set(key, v)
+					continue
+				}
+			} else if L.metaOf(obj, "__newindex") == nil {
+				die("attempt to index a %s value%s", valType(obj), p.info[pc-1][0])
+			}
+			L.opSetIndex(obj, key, v)
+		case opNEWTABLE:
+			regs[a] = newTable(0, i.c())
+		case opSELF:
+			obj, key := regs[i.b()], rk(regs, k, i.c())
+			if _, ok := obj.(*luaTable); !ok && L.metaOf(obj, "__index") == nil {
+				die("attempt to index a %s value%s", valType(obj), p.info[pc-1][0])
+			}
+			regs[a+1] = obj
+			regs[a] = L.opIndex(obj, key)
+
+		case opADD:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					regs[a] = m + n
+					continue
+				}
+			} else if m, ok := x.(float64); ok {
+				if n, ok := y.(float64); ok {
+					regs[a] = m + n
+					continue
+				}
+			}
+			regs[a] = L.operator(opADD, x, y, p, pc-1)
+		case opSUB:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					regs[a] = m - n
+					continue
+				}
+			} else if m, ok := x.(float64); ok {
+				if n, ok := y.(float64); ok {
+					regs[a] = m - n
+					continue
+				}
+			}
+			regs[a] = L.operator(opSUB, x, y, p, pc-1)
+		case opMUL:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					regs[a] = m * n
+					continue
+				}
+			} else if m, ok := x.(float64); ok {
+				if n, ok := y.(float64); ok {
+					regs[a] = m * n
+					continue
+				}
+			}
+			regs[a] = L.operator(opMUL, x, y, p, pc-1)
+		case opMOD, opPOW, opDIV, opIDIV, opBAND, opBOR, opBXOR, opSHL, opSHR:
+			regs[a] = L.operator(i.op(), rk(regs, k, i.b()), rk(regs, k, i.c()), p, pc-1)
+		case opCONCAT:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			if s, ok := x.(string); ok {
+				if t, ok := y.(string); ok {
+					regs[a] = s + t
+					continue
+				}
+			}
+			regs[a] = L.operator(opCONCAT, x, y, p, pc-1)
+		case opUNM, opBNOT, opLEN:
+			regs[a] = L.operator(i.op(), regs[i.b()], nil, p, pc-1)
+		case opNOT:
+			regs[a] = opNot(regs[i.b()])
+
+		case opJMP:
+			pc += i.sbx()
+		case opEQ:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			var r bool
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					r = m == n
+				} else {
+					r = L.opEQ(x, y)
+				}
+			} else {
+				r = L.opEQ(x, y)
+			}
+			if r != (a != 0) {
+				pc++
+			}
+		case opLT:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			var r bool
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					r = m < n
+				} else {
+					r = L.opLT(x, y)
+				}
+			} else {
+				r = L.opLT(x, y)
+			}
+			if r != (a != 0) {
+				pc++
+			}
+		case opLE:
+			x, y := rk(regs, k, i.b()), rk(regs, k, i.c())
+			var r bool
+			if m, ok := x.(int64); ok {
+				if n, ok := y.(int64); ok {
+					r = m <= n
+				} else {
+					r = L.opLE(x, y)
+				}
+			} else {
+				r = L.opLE(x, y)
+			}
+			if r != (a != 0) {
+				pc++
+			}
+		case opTEST:
+			if truthy(regs[a]) != (i.c() != 0) {
+				pc++
+			}
+
+		case opCALL:
+			fn := regs[a]
+			var args []interface{}
+			if b := i.b(); b != 0 {
+				args = make([]interface{}, b-1)
+				copy(args, regs[a+1:])
+			} else {
+				args = make([]interface{}, mbase-a-1+len(mret))
+				copy(args[copy(args, regs[a+1:mbase]):], mret)
+			}
+			if !callable(fn) && L.metaOf(fn, "__call") == nil {
+				die("attempt to call a %s value%s", valType(fn), p.info[pc-1][0])
+			}
+			rs := L.call(fn, args...)
+			if c := i.c(); c != 0 {
+				for j := 0; j < c-1; j++ {
+					regs[a+j] = argAt(rs, j)
+				}
+			} else {
+				mret, mbase = rs, a
+			}
+		case opRETURN:
+			if b := i.b(); b != 0 {
+				rs := make([]interface{}, b-1)
+				copy(rs, regs[a:])
+				return rs
+			}
+			rs := make([]interface{}, mbase-a+len(mret))
+			copy(rs[copy(rs, regs[a:mbase]):], mret)
+			return rs
+
+		case opFORCHECK:
+			v := coerce(regs[a])
+			if !isNumber(v) {
+				die("%s", forErrors[i.c()])
+			}
+			regs[a] = v
+		case opFORPREP:
+			start, limit, step := regs[a], regs[a+1], regs[a+2]
+			if !isNumber(start) || !isNumber(limit) || !isNumber(step) {
+				badCode()
+			}
+			if numEQ(step, int64(0)) {
+				die("'for' step is zero")
+			}
+			st := &forState{}
+			i0, ok1 := start.(int64)
+			di, ok2 := step.(int64)
+			if ok1 && ok2 {
+				n, skip := forLimit(limit, di)
+				if skip || (di > 0 && i0 > n) || (di < 0 && i0 < n) {
+					pc += i.sbx()
+					continue
+				}
+				st.i, st.step = i0, di
+				if di > 0 {
+					st.count = (uint64(n) - uint64(i0)) / uint64(di)
+				} else {
+					st.count = (uint64(i0) - uint64(n)) / (uint64(-(di + 1)) + 1)
+				}
+				regs[a+3] = i0
+			} else {
+				f0, _ := toFloat(start)
+				fn, _ := toFloat(limit)
+				df, _ := toFloat(step)
+				if !(df > 0 && f0 <= fn || df < 0 && f0 >= fn) {
+					pc += i.sbx()
+					continue
+				}
+				st.float, st.f, st.flimit, st.fstep = true, f0, fn, df
+				regs[a+3] = f0
+			}
+			regs[a] = st
+		case opFORLOOP:
+			st, ok := regs[a].(*forState)
+			if !ok {
+				badCode()
+			}
+			if st.float {
+				st.f += st.fstep
+				if st.fstep > 0 && st.f <= st.flimit || st.fstep < 0 && st.f >= st.flimit {
+					regs[a+3] = st.f
+					pc += i.sbx()
+				}
+			} else if st.count > 0 {
+				st.count--
+				st.i += st.step
+				regs[a+3] = st.i
+				pc += i.sbx()
+			}
+		case opTFORCALL:
+			rs := L.call(regs[a], regs[a+1], regs[a+2])
+			for j := 0; j < i.c(); j++ {
+				regs[a+3+j] = argAt(rs, j)
+			}
+		case opTFORLOOP:
+			if regs[a+1] != nil {
+				regs[a] = regs[a+1]
+				pc += i.sbx()
+			}
+
+		case opSETLIST:
+			var items []interface{}
+			if b := i.b(); b != 0 {
+				items = append(pending[a], regs[a+1:a+1+b]...)
+			} else {
+				items = append(append(pending[a], regs[a+1:mbase]...), mret...)
+			}
+			if i.c() == 0 {
+				if pending == nil {
+					pending = map[int][]interface{}{}
+				}
+				pending[a] = items
+				continue
+			}
+			delete(pending, a)
+			if len(items) > 0 {
+				// like the evaluator, positional items replace the array part
+				t, ok := regs[a].(*luaTable)
+				if !ok {
+					badCode()
+				}
+				for j := range items {
+					t.hashSet(int64(j+1), nil)
+				}
+				t.arr = items
+				t.migrate()
+			}
+		case opCLOSURE:
+			np := p.protos[i.bx()]
+			ups := make([]*upval, len(np.upvals))
+			for j, d := range np.upvals {
+				if !d.inStack {
+					ups[j] = cl.upvals[d.index]
+					continue
+				}
+				for _, u := range open {
+					if u.reg == d.index {
+						ups[j] = u
+						break
+					}
+				}
+				if ups[j] == nil {
+					ups[j] = &upval{p: &regs[d.index], reg: d.index}
+					open = append(open, ups[j])
+				}
+			}
+			regs[a] = &luaClosure{p: np, upvals: ups}
+		case opVARARG:
+			if b := i.b(); b != 0 {
+				for j := 0; j < b-1; j++ {
+					regs[a+j] = argAt(varargs, j)
+				}
+			} else {
+				mret, mbase = varargs, a
+			}
+		case opCLOSE:
+			n := 0
+			for _, u := range open {
+				if u.reg >= a {
+					u.v = *u.p
+					u.p = &u.v
+				} else {
+					open[n] = u
+					n++
+				}
+			}
+			open = open[:n]
+		default:
+			panic("unknown opcode")
+		}
+	}
+}
@@ -1,123 +1,93 @@
 package main
 
 import (
-	"errors"
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path"
-	"strconv"
+	"time"
+
+	"github.com/ddosakura/pet-shop/gotools-testing/lua/glua"
 )
 
 var (
-	logMode = false
+	engine = flag.String("engine", "vm", "how to run scripts: vm or tree")
+	output = flag.String("o", "", "precompile the script to this file instead of running it")
+	strip  = flag.Bool("s", false, "strip debug information from the precompiled script")
 )
 
 func main() {
-	var lex *Lexer
-	var filename string
-	if len(os.Args) == 1 {
-		filename = "stdin"
-		logMode = true
-
-		r, w := io.Pipe()
-		lex = NewLexer(r)
-		w.Write([]byte("\nprint(_VERSION)\n"))
-		go io.Copy(w, os.Stdin)
-	} else {
-		filename = os.Args[1]
-		if !path.IsAbs(filename) {
-			filename = "./" + filename
-		}
-		f, e := os.Open(filename)
-		if e != nil {
-			panic(e)
-		} else {
-			lex = NewLexer(f)
-		}
+	flag.Parse()
+	L := glua.NewState()
+	switch *engine {
+	case "vm":
+	case "tree":
+		L.SetEngine(glua.TreeWalker)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engine)
+		os.Exit(2)
 	}
-	for callParse(filename, lex) {
+	if flag.NArg() == 0 {
+		fmt.Println(L.GetGlobal("_VERSION"))
+		r, w := io.Pipe()
+		go prompt(w)
+		L.Interact(r, "stdin", report)
+		return
 	}
-}
 
-func callParse(filename string, lex *Lexer) (b bool) {
-	defer func() {
-		if e := recover(); e != nil {
-			err := errors.New(fmt.Sprint(e))
-			if logMode {
-				fmt.Printf("%s:%d:%d: %s\n", filename, lex.Line()-1, lex.Column()+1, err.Error())
-			} else {
-				fmt.Printf("%s:%d:%d: %s\n", filename, lex.Line()+1, lex.Column()+1, err.Error())
-			}
-			b = true
+	filename := flag.Arg(0)
+	if !path.IsAbs(filename) {
+		filename = "./" + filename
+	}
+	if *output != "" {
+		if err := precompile(filename, *output); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-	}()
-	if lex != nil {
-		yyParse(lex)
+		return
+	}
+	if err := L.DoFile(filename); err != nil {
+		report(err)
+		os.Exit(1)
 	}
-	return false
 }
 
-type luaFunc func(...interface{}) interface{}
-type luaTable map[string]interface{}
-type luaCoroutine struct{}
-
-var (
-	vals  map[string]interface{}
-	funcs map[string]luaFunc
-)
-
-func init() {
-	vals = map[string]interface{}{
-		"_VERSION": "Lua 5.3 (BETA) ddosakura",
+// precompile writes the binary chunk of the script filename to output.
+func precompile(filename, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
 	}
-	funcs = map[string]luaFunc{
-		"print": func(args ...interface{}) interface{} {
-			for i, a := range args {
-				if i == 0 {
-					fmt.Printf("%v", a)
-				} else {
-					fmt.Printf("\t%v", a)
-				}
-			}
-			fmt.Println()
-			return nil
-		},
-		"type": func(args ...interface{}) interface{} {
-			if len(args) == 0 {
-				panic("bad argument #1 to 'type' (value expected)")
-			}
-			return valType(args[0])
-		},
+	if err := glua.CompileFile(filename, f, *strip); err != nil {
+		f.Close()
+		os.Remove(output)
+		return err
 	}
+	return f.Close()
 }
 
-func valType(a interface{}) string {
-	switch a.(type) {
-	case nil:
-		return "nil"
-	case string:
-		return "string"
-	case bool:
-		return "boolean"
-	case float64:
-		return "number"
-	case luaFunc:
-		return "function"
-	case luaTable:
-		return "table"
-	case luaCoroutine:
-		return "thread"
-	default:
-		// return "userdata"
-		return ""
+// prompt copies the standard input to w a line at a time, and prompts for
+// the next line once the statement had time to print its output.
+func prompt(w *io.PipeWriter) {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		print("> ")
+		line, err := in.ReadString('\n')
+		w.Write([]byte(line))
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
 	}
 }
 
-func unquote(s string) string {
-	a, e := strconv.Unquote(`"` + s + `"`)
-	if e != nil {
-		panic(e)
+// report prints an error, os.exit ends the process with its status.
+func report(err error) {
+	if e, ok := err.(*glua.ExitError); ok {
+		os.Exit(e.Code)
 	}
-	return a
+	fmt.Println(err)
 }
@@ -0,0 +1,53 @@
+#!/bin/sh
+# cli.sh checks what ./lua prints for the demo scripts and how it reports
+# syntax and runtime errors. It runs from the directory of the Makefile.
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+status=0
+
+# check name want cmd...: cmd must print want and exit with the status on
+# the last line of it.
+check() {
+	name=$1 want=$2
+	shift 2
+	got=$("$@" 2>&1; echo "exit $?")
+	if [ "$got" != "$want" ]; then
+		printf '%s: got\n%s\nwant\n%s\n' "$name" "$got" "$want"
+		status=1
+	fi
+}
+
+check main.lua "-8.0
+6.5	3
+1.0	false	nil	3.0
+1	2
+Hello,
+	World!	0
+false
+6
+12
+exit 0" ./lua main.lua
+
+check expr.lua "false
+true
+false
+true
+0.2
+exit 0" ./lua expr.lua
+
+printf 'x = = 1\n' >"$tmp/syntax.lua"
+check "syntax error" "$tmp/syntax.lua:1:5: syntax error
+exit 1" ./lua "$tmp/syntax.lua"
+
+printf 'print(1)\nfoo()\nprint(2)\n' >"$tmp/runtime.lua"
+check "runtime error" "1
+$tmp/runtime.lua:2: attempt to call a nil value (global 'foo')
+exit 1" ./lua "$tmp/runtime.lua"
+
+printf 'print(1)\nerror("boom")\nprint(2)\n' >"$tmp/error.lua"
+check "error" "1
+$tmp/error.lua:2: boom
+exit 1" ./lua "$tmp/error.lua"
+
+exit $status
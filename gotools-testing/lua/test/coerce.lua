@@ -0,0 +1,61 @@
+-- string and number coercion
+
+-- numeric strings convert for arithmetic
+assert("10" + 1 == 11)
+assert(math.type("10" + 1) == "integer")
+assert("3.0" + 1 == 4.0)
+assert(math.type("3.0" + 1) == "float")
+assert("0x10" * 2 == 32)
+assert(" 5 " - 1 == 4)
+assert(-"2" == -2)
+assert("10" // "3" == 3)
+assert("7" % 2 == 1)
+assert("2" ^ 2 == 4.0)
+assert("3" | 0 == 3)
+assert(~"0" == -1)
+
+-- numbers convert for concatenation
+assert("n=" .. 1 == "n=1")
+assert(1 .. 2 == "12")
+assert(1.5 .. "" == "1.5")
+assert(2.0 .. "" == "2.0")
+assert(-0.0 .. "" == "-0.0")
+assert(1 .. 2 + 3 == "15")
+assert("x" .. 10 // 3 == "x3")
+assert(#"ab" .. "c" == "2c")
+
+-- comparisons do not convert
+assert("10" ~= 10)
+assert("1" < "2")
+
+-- tonumber
+assert(tonumber("10") == 10)
+assert(math.type(tonumber("10")) == "integer")
+assert(tonumber("1e1") == 10.0)
+assert(math.type(tonumber("1e1")) == "float")
+assert(tonumber("  0x1p4  ") == 16.0)
+assert(tonumber("-0x10") == -16)
+assert(tonumber(".5") == 0.5)
+assert(tonumber(12) == 12)
+assert(tonumber("abc") == nil)
+assert(tonumber("") == nil)
+assert(tonumber("- 1") == nil)
+assert(tonumber("1e") == nil)
+assert(tonumber("inf") == nil)
+assert(tonumber("nan") == nil)
+assert(tonumber(nil) == nil)
+assert(tonumber({}) == nil)
+assert(tonumber("ff", 16) == 255)
+assert(tonumber("zz", 36) == 1295)
+assert(tonumber("-101", 2) == -5)
+assert(tonumber("8", 8) == nil)
+
+-- numeric for and integer arguments accept numeric strings
+local n = 0
+for i = "1", "3" do
+    n = n + i
+end
+assert(n == 6)
+assert(select("2", "a", "b") == "b")
+
+print("ok")
@@ -0,0 +1,147 @@
+-- coroutines
+
+-- values pass both ways through resume and yield
+local co = coroutine.create(function(a, b)
+    local c = coroutine.yield(a + b)
+    local d, e = coroutine.yield(c * 2)
+    return d + e, "done"
+end)
+assert(type(co) == "thread")
+assert(coroutine.status(co) == "suspended")
+local ok, v = coroutine.resume(co, 1, 2)
+assert(ok)
+assert(v == 3)
+assert(coroutine.status(co) == "suspended")
+ok, v = coroutine.resume(co, 10)
+assert(v == 20)
+local ok2, sum, msg = coroutine.resume(co, 3, 4)
+assert(ok2)
+assert(sum == 7)
+assert(msg == "done")
+assert(coroutine.status(co) == "dead")
+ok, v = coroutine.resume(co)
+assert(not ok)
+assert(v == "cannot resume dead coroutine")
+
+-- generators with wrap
+local function range(n)
+    return coroutine.wrap(function()
+        for i = 1, n do
+            coroutine.yield(i)
+        end
+    end)
+end
+local total = 0
+for i in range(4) do
+    total = total + i
+end
+assert(total == 10)
+
+-- yielding across nested Lua calls
+local function walk(t)
+    for _, v in ipairs(t) do
+        if type(v) == "table" then
+            walk(v)
+        else
+            coroutine.yield(v)
+        end
+    end
+end
+local leaves = {}
+for v in coroutine.wrap(function() walk({1, {2, {3, 4}}, 5}) end) do
+    leaves[#leaves + 1] = v
+end
+assert(#leaves == 5)
+assert(leaves[3] == 3)
+assert(leaves[5] == 5)
+
+-- a state machine
+local light = coroutine.wrap(function()
+    while true do
+        coroutine.yield("green")
+        coroutine.yield("yellow")
+        coroutine.yield("red")
+    end
+end)
+assert(light() == "green")
+assert(light() == "yellow")
+assert(light() == "red")
+assert(light() == "green")
+
+-- errors end the coroutine
+co = coroutine.create(function() error("oops", 0) end)
+ok, v = coroutine.resume(co)
+assert(not ok)
+assert(v == "oops")
+assert(coroutine.status(co) == "dead")
+co = coroutine.create(function() error({code = 7}) end)
+ok, v = coroutine.resume(co)
+assert(v.code == 7)
+local w = coroutine.wrap(function() error("bad", 0) end)
+ok, v = pcall(w)
+assert(not ok)
+assert(v == "bad")
+
+-- pcall inside a coroutine survives yields
+co = coroutine.create(function()
+    local ok, e = pcall(function()
+        coroutine.yield(1)
+        error("after", 0)
+    end)
+    coroutine.yield(ok, e)
+    return "end"
+end)
+local _, a = coroutine.resume(co)
+assert(a == 1)
+local _, b, c = coroutine.resume(co)
+assert(b == false)
+assert(c == "after")
+local _, d = coroutine.resume(co)
+assert(d == "end")
+
+-- status, running and isyieldable
+local main, ismain = coroutine.running()
+assert(type(main) == "thread")
+assert(ismain)
+assert(not coroutine.isyieldable())
+ok, v = coroutine.resume(main)
+assert(not ok)
+assert(v == "cannot resume non-suspended coroutine")
+ok, v = pcall(coroutine.yield)
+assert(not ok)
+
+local outer
+outer = coroutine.create(function()
+    local self, ismain = coroutine.running()
+    assert(self == outer)
+    assert(not ismain)
+    assert(coroutine.isyieldable())
+    assert(coroutine.status(outer) == "running")
+    local inner = coroutine.create(function()
+        assert(coroutine.status(outer) == "normal")
+        coroutine.yield()
+    end)
+    assert(coroutine.resume(inner))
+    assert(coroutine.status(inner) == "suspended")
+    ok, v = coroutine.resume(outer)
+    assert(v == "cannot resume non-suspended coroutine")
+end)
+ok, v = coroutine.resume(outer)
+assert(ok, v)
+assert(coroutine.status(outer) == "dead")
+
+-- producer and consumer
+local producer = coroutine.create(function()
+    for _, item in ipairs({"a", "b", "c"}) do
+        coroutine.yield(item)
+    end
+end)
+local got = ""
+while true do
+    local _, item = coroutine.resume(producer)
+    if item == nil then break end
+    got = got .. item
+end
+assert(got == "abc")
+
+print("ok")
@@ -0,0 +1,121 @@
+-- error, pcall and xpcall
+
+-- any value can be an error
+local ok, e = pcall(error, "x")
+assert(not ok)
+assert(e == "x")
+local t = {}
+ok, e = pcall(error, t)
+assert(e == t)
+ok, e = pcall(error)
+assert(not ok)
+assert(e == nil)
+ok, e = pcall(function() error(42) end)
+assert(e == 42)
+
+-- pcall returns all results on success
+local a, b, c = pcall(function(x, y) return x + y, "two" end, 1, 2)
+assert(a == true)
+assert(b == 3)
+assert(c == "two")
+
+-- string messages get the position of the caller of error, level 0 none
+ok, e = pcall(function() error("m", 0) end)
+assert(e == "m")
+local _, here = pcall(function() error("m") end) local _, there = pcall(function() error("m", 1) end)
+assert(here == there)
+assert(here ~= "m")
+local _, other = pcall(function() error("m") end)
+assert(other ~= here)
+
+-- level 2 blames the caller of the function calling error
+local function check(v)
+    if not v then error("check failed", 2) end
+end
+local _, l2 = pcall(function() check(false) end) local _, l1 = pcall(function() error("check failed") end)
+assert(l2 == l1)
+
+-- runtime errors are positioned like error messages
+local _, r1 = pcall(function() local x = nil + 1 end) local _, r2 = pcall(function() error("attempt to perform arithmetic on a nil value") end)
+assert(r1 == r2)
+
+-- operator errors name the variable of the bad operand
+local up
+ok, e = pcall(function() local x; return x + 1 end)
+assert(e:find("attempt to perform arithmetic on a nil value (local 'x')", 1, true))
+ok, e = pcall(function() return 1 - up end)
+assert(e:find("attempt to perform arithmetic on a nil value (upvalue 'up')", 1, true))
+ok, e = pcall(function() return -undefined end)
+assert(e:find("attempt to perform arithmetic on a nil value (global 'undefined')", 1, true))
+ok, e = pcall(function() local s = "a"; return s .. t.field end)
+assert(e:find("attempt to concatenate a nil value (field 'field')", 1, true))
+ok, e = pcall(function() local x = {}; return 1 | x end)
+assert(e:find("attempt to perform bitwise operation on a table value (local 'x')", 1, true))
+ok, e = pcall(function() return #up end)
+assert(e:find("attempt to get length of a nil value (upvalue 'up')", 1, true))
+ok, e = pcall(function() return {} .. "a" end)
+assert(e:find("attempt to concatenate a table value$"))
+
+-- builtins called directly by pcall have no position
+ok, e = pcall(setmetatable, 1)
+assert(e == "bad argument #1 to 'setmetatable' (table expected, got number)")
+
+-- assert
+ok, e = pcall(assert, false, "msg")
+assert(e == "msg")
+ok, e = pcall(assert, nil, t)
+assert(e == t)
+ok, e = pcall(assert, false)
+assert(e == "assertion failed!")
+assert(select("#", assert(1, 2, 3)) == 3)
+
+-- nested pcall
+a, b, c = pcall(pcall, error, "x")
+assert(a == true)
+assert(b == false)
+assert(c == "x")
+
+-- errors unwind through loops and calls
+local n = 0
+ok = pcall(function()
+    for i = 1, 10 do
+        n = i
+        if i == 3 then error("stop") end
+    end
+end)
+assert(not ok)
+assert(n == 3)
+
+-- stack overflow is an ordinary error
+local function rec() return rec() + 1 end
+ok, e = pcall(rec)
+assert(not ok)
+
+-- xpcall runs the handler with the error value
+ok, e = xpcall(function() error({code = 1}) end, function(err) return err.code end)
+assert(not ok)
+assert(e == 1)
+ok, e = xpcall(function(x) return x * 2 end, print, 21)
+assert(ok)
+assert(e == 42)
+
+-- errors in the handler are caught too
+ok, e = xpcall(function() error("a") end, function() error("b", 0) end)
+assert(not ok)
+assert(e == "b")
+
+-- error objects with __tostring
+local E = setmetatable({}, {__tostring = function() return "E" end})
+ok, e = pcall(error, E)
+assert(tostring(e) == "E")
+
+-- builtins check their arguments instead of failing in Go
+local f = ipairs({})
+ok, e = pcall(f)
+assert(not ok)
+assert(e:find("bad argument #2 to 'ipairs' (number expected, got no value)", 1, true))
+ok, e = pcall(f, {}, "x")
+assert(not ok)
+assert(e:find("bad argument #2 to 'ipairs' (number expected, got string)", 1, true))
+
+print("ok")
@@ -0,0 +1,75 @@
+-- operator precedence and associativity
+
+local function check(got, want)
+    if got ~= want then
+        assert(false, "got " .. tostring(got) .. ", want " .. tostring(want))
+    end
+end
+
+-- chaining and left associativity
+check(1 + 2 + 3, 6)
+check(10 - 4 - 3, 3)
+check(2 * 3 * 4, 24)
+check(100 / 10 / 2, 5.0)
+check(100 // 7 // 2, 7)
+check(100 % 7 % 4, 2)
+check(1 - 2 + 3, 2)
+check(8 / 4 * 2, 4.0)
+check(0xff & 0x0f & 0x03, 3)
+check(1 | 2 | 4, 7)
+check(7 ~ 2 ~ 1, 4)
+check(1 << 2 << 3, 32)
+check(256 >> 2 >> 3, 8)
+
+-- right associativity
+check(2 ^ 3 ^ 2, 512.0)
+check((2 ^ 3) ^ 2, 64.0)
+check("a" .. "b" .. "c", "abc")
+
+-- precedence table, from low to high:
+-- or / and / comparisons / | / ~ / & / << >> / .. / + - / * / // % /
+-- unary / ^
+check(1 + 2 * 3, 7)
+check((1 + 2) * 3, 9)
+check(2 * 3 ^ 2, 18.0)
+check(-2 ^ 2, -4.0)
+check(2 ^ -1, 0.5)
+check(-2 ^ -2, -0.25)
+check(- -2, 2)
+check(not not nil, false)
+check(#"abc" + 1, 4)
+check(-#"abc", -3)
+check("a" .. "b" == "ab", true)
+check("a" .. "b" < "ab" .. "c", true)
+check(1 << 2 + 1, 8)
+check(3 & 2 << 1, 0)
+check(6 & 3 ~ 1, 3)
+check(1 | 6 & 3, 3)
+check(1 | 2 ~ 3, 1)
+check(5 ~ 1 | 2, 6)
+check(~0 & 0xf, 15)
+check(~5 + 1, -5)
+check(1 | 2 == 3, true)
+check(1 < 2 == true, true)
+check(1 + 1 == 2 and 3 > 2, true)
+check(1 == 2 or 3 == 3, true)
+check(nil and 1 or 2, 2)
+check(false or nil and 1, nil)
+check(1 or 2 and nil, 1)
+check(not 1 == 2, false)
+check(not (1 == 2), true)
+check(2 * 3 % 4, 2)
+check(7 // 2 * 2, 6)
+check(10 - 2 - 3 * 2 ^ 2, -4.0)
+
+-- parentheses around any expression, truncating to one value
+local function two() return 1, 2 end
+local t = {(two())}
+check(#t, 1)
+check((1 < 2), true)
+check(((1 + 2)) * ((3)), 9)
+
+-- comparisons chain to the left
+check(3 > 2 == false, false)
+
+print("ok")
@@ -0,0 +1,65 @@
+-- numeric and generic for
+
+n = 0
+for i = 1, 10 do n = n + i end
+assert(n == 55)
+
+-- the limit is inclusive, an empty range never runs the body
+n = 0
+for i = 1, 1 do n = n + 1 end
+assert(n == 1)
+for i = 1, 0 do n = n + 1 end
+assert(n == 1)
+
+-- explicit and negative steps
+n = 0
+for i = 1, 10, 3 do n = n + i end
+assert(n == 22)
+n = 0
+for i = 10, 1, -1 do n = n + i end
+assert(n == 55)
+n = 0
+for i = 10, 1, -4 do n = n + i end
+assert(n == 18)
+n = 0
+for i = 0, 1, 0.25 do n = n + 1 end
+assert(n == 5)
+
+-- the bounds are evaluated once
+m = 3
+n = 0
+for i = 1, m do
+    m = 10
+    n = n + 1
+end
+assert(n == 3)
+
+-- the control variable is local to the loop and a copy
+i = "global"
+n = 0
+for i = 1, 3 do
+    i = i * 10
+    n = n + i
+end
+assert(n == 60)
+assert(i == "global")
+
+-- nested loops and break
+n = 0
+for i = 1, 5 do
+    for j = 1, 5 do
+        if j > i then break end
+        n = n + 1
+    end
+end
+assert(n == 15)
+
+-- generic for calls f(s, control) until the first value is nil
+r = nil
+for a, b in assert, "state" do
+    r = a
+    break
+end
+assert(r == "state")
+
+print("ok")
@@ -0,0 +1,161 @@
+-- functions, closures and return values
+
+function add(a, b)
+    return a + b
+end
+assert(add(1, 2) == 3)
+
+-- missing arguments are nil, extra ones are dropped
+function second(a, b)
+    return b
+end
+assert(second(1) == nil)
+assert(second(1, 2, 3) == 2)
+
+-- no return statement gives no values
+function none() end
+assert(none() == nil)
+
+-- multiple results are adjusted to the context
+function three()
+    return 1, 2, 3
+end
+a, b, c, d = three()
+assert(a == 1)
+assert(b == 2)
+assert(c == 3)
+assert(d == nil)
+a, b, c = three(), 10
+assert(a == 1)
+assert(b == 10)
+assert(c == nil)
+a, b = (three())
+assert(a == 1)
+assert(b == nil)
+assert(second(three()) == 2)
+
+-- anonymous functions are values
+sub = function(a, b) return a - b end
+assert(sub(5, 3) == 2)
+assert(type(sub) == "function")
+apply = function(f, a, b) return f(a, b) end
+assert(apply(add, 2, 3) == 5)
+assert(apply(function(a, b) return a * b end, 2, 3) == 6)
+
+-- recursion
+function fact(n)
+    if n <= 1 then return 1 end
+    return n * fact(n - 1)
+end
+assert(fact(10) == 3628800)
+
+local function fib(n)
+    if n < 2 then return n end
+    return fib(n - 1) + fib(n - 2)
+end
+assert(fib(20) == 6765)
+
+-- return inside loops
+function find(n)
+    for i = 1, 100 do
+        while true do
+            if i * i >= n then return i end
+            break
+        end
+    end
+    return "none"
+end
+assert(find(50) == 8)
+assert(find(100000) == "none")
+
+-- closures capture variables by reference
+function counter()
+    local function inc(n)
+        return n + 1
+    end
+    count = 0
+    return function()
+        count = inc(count)
+        return count
+    end
+end
+c1 = counter()
+assert(c1() == 1)
+assert(c1() == 2)
+
+function makeCounter(n)
+    return function(step)
+        n = n + step
+        return n
+    end, function() return n end
+end
+inc, get = makeCounter(10)
+inc2, get2 = makeCounter(100)
+assert(inc(1) == 11)
+assert(inc(5) == 16)
+assert(get() == 16)
+assert(inc2(1) == 101)
+assert(get() == 16)
+
+-- every iteration of a loop has a fresh control variable
+function collect()
+    local function mk(i) return function() return i end end
+    first = nil
+    last = nil
+    for i = 1, 3 do
+        f = function() return i end
+        if i == 1 then first = f end
+        last = f
+    end
+end
+collect()
+assert(first() == 1)
+assert(last() == 3)
+
+-- varargs
+function count(...)
+    return select("#", ...)
+end
+assert(count() == 0)
+assert(count(nil, nil) == 2)
+function pass(...)
+    return ...
+end
+a, b, c = pass(1, 2)
+assert(a == 1)
+assert(b == 2)
+assert(c == nil)
+function skip(a, ...)
+    return select(2, ...)
+end
+assert(skip(1, 2, 3, 4) == 3)
+function firstOf(...)
+    return (...)
+end
+assert(firstOf(7, 8) == 7)
+
+-- generic for with a stateless iterator written in Lua
+function iter(limit, i)
+    if i < limit then return i + 1, i * 2 end
+end
+n = 0
+for i, d in iter, 4, 0 do
+    n = n + d
+end
+assert(n == 12)
+
+-- and with a closure based one
+function range(n)
+    local function next()
+        if n > 0 then
+            n = n - 1
+            return n
+        end
+    end
+    return next
+end
+n = 0
+for i in range(5) do n = n + i end
+assert(n == 10)
+
+print("ok")
@@ -0,0 +1,95 @@
+-- if / elseif / else
+
+r = 0
+if true then r = 1 end
+assert(r == 1)
+
+r = 0
+if false then r = 1 end
+assert(r == 0)
+
+r = 0
+if false then r = 1 else r = 2 end
+assert(r == 2)
+
+-- only nil and false are falsy
+r = 0
+if 0 then r = 1 end
+assert(r == 1)
+r = 0
+if "" then r = 1 end
+assert(r == 1)
+r = 0
+if nil then r = 1 else r = 2 end
+assert(r == 2)
+r = 0
+if undefined then r = 1 end
+assert(r == 0)
+
+-- elseif chain, first match wins
+x = 2
+if x == 1 then
+    r = "one"
+elseif x == 2 then
+    r = "two"
+elseif x == 2 then
+    r = "two again"
+else
+    r = "other"
+end
+assert(r == "two")
+
+x = 5
+if x == 1 then
+    r = "one"
+elseif x == 2 then
+    r = "two"
+else
+    r = "other"
+end
+assert(r == "other")
+
+x = 5
+r = "unchanged"
+if x == 1 then
+    r = "one"
+elseif x == 2 then
+    r = "two"
+end
+assert(r == "unchanged")
+
+-- nesting
+a = 1
+b = 2
+if a == 1 then
+    if b == 1 then
+        r = "a1b1"
+    elseif b == 2 then
+        if a < b then
+            r = "a1b2"
+        else
+            r = "wrong"
+        end
+    else
+        r = "a1b?"
+    end
+else
+    r = "a?"
+end
+assert(r == "a1b2")
+
+-- empty branches
+r = 0
+if true then end
+if false then end
+if true then else end
+if false then else end
+if false then elseif true then else end
+if false then
+elseif false then
+else
+end
+if true then ; end
+assert(r == 0)
+
+print("ok")
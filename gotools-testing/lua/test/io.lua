@@ -0,0 +1,110 @@
+-- the io library
+
+local name = "io.tmp"
+
+assert(type(io.stdout) == "userdata")
+assert(io.type(io.stdout) == "file")
+assert(io.type(42) == nil)
+assert(string.find(tostring(io.stdout), "^file %("))
+assert(io.write() == io.stdout)
+assert(io.stdout:write("") == io.stdout)
+
+-- write, then read back in every format
+local f = assert(io.open(name, "w"))
+assert(type(f) == "userdata")
+assert(f:write("first line\n", 42, " ", 3.5, "\n") == f)
+f:write("0x1F -7 1e2 nope\n")
+f:write("last")
+assert(f:close() == true)
+assert(io.type(f) == "closed file")
+assert(tostring(f) == "file (closed)")
+assert(not pcall(f.write, f, "x"))
+
+f = assert(io.open(name))
+assert(f:read() == "first line")
+assert(f:read("n") == 42)
+local a, b = f:read("n", "l")
+assert(a == 3.5 and b == "")
+a, b = f:read("*n", "n")
+assert(a == 31 and b == -7)
+a = f:read("n")
+assert(a == 100.0 and math.type(a) == "float")
+assert(f:read("n") == nil)
+assert(f:read("L") == "nope\n")
+assert(f:read(2) == "la")
+assert(f:read(0) == "")
+assert(f:read("a") == "st")
+assert(f:read(0) == nil)
+assert(f:read("l") == nil)
+assert(f:read("a") == "")
+local ok, e = pcall(f.read, f, "x")
+assert(not ok and string.find(e, "invalid format"))
+
+-- seek
+assert(f:seek("set") == 0)
+assert(f:read(5) == "first")
+assert(f:seek() == 5)
+assert(f:seek("cur", 1) == 6)
+assert(f:read("l") == "line")
+local size = f:seek("end")
+assert(f:read("a") == "")
+assert(f:seek("set", size - 4) == size - 4)
+assert(f:read("a") == "last")
+f:close()
+
+-- append and read-write modes
+f = assert(io.open(name, "a"))
+f:write("\nappended")
+f:close()
+f = assert(io.open(name, "r+"))
+assert(f:read() == "first line")
+f:seek("set", 0)
+f:write("FIRST")
+f:seek("set", 0)
+assert(f:read() == "FIRST line")
+f:close()
+
+-- lines
+local all = {}
+for l in io.lines(name) do all[#all + 1] = l end
+assert(#all == 5 and all[1] == "FIRST line" and all[5] == "appended")
+f = assert(io.open(name))
+local n = 0
+for a, b in f:lines(1, 1) do
+    n = n + 1
+    if n == 1 then assert(a == "F" and b == "I") end
+end
+assert(io.type(f) == "file")
+f:close()
+local nums = {}
+f = io.open(name, "w")
+f:write("1 2 3")
+f:close()
+for x in io.lines(name, "n") do nums[#nums + 1] = x end
+assert(#nums == 3 and nums[3] == 3)
+
+-- input and output
+io.output(name)
+io.write("via", " output")
+assert(io.close() == true)
+io.output(io.stdout)
+io.input(name)
+assert(io.read("a") == "via output")
+io.input():close()
+ok, e = pcall(io.read)
+assert(e == "standard input file is closed")
+io.input(io.stdin)
+
+-- failures
+local r, msg, code = io.open("no/such/file")
+assert(r == nil and msg == "no/such/file: No such file or directory")
+assert(math.type(code) == "integer")
+ok, e = pcall(io.open, name, "rw")
+assert(e == "bad argument #2 to 'open' (invalid mode)")
+assert(not pcall(io.lines, "no/such/file"))
+r, msg = io.stdout:close()
+assert(r == nil and msg == "cannot close standard file")
+
+assert(os.remove(name))
+
+print("ok")
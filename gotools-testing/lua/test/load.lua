@@ -0,0 +1,90 @@
+-- load and string.dump
+
+-- source chunks
+local f = load("return 1 + ...")
+assert(f(2) == 3)
+local n = 0
+local parts = {"return ", "'pie", "ces'"}
+f = load(function() n = n + 1 return parts[n] end)
+assert(f() == "pieces")
+local ok, e = load("return +")
+assert(ok == nil and string.find(e, "syntax error"))
+ok, e = load(function() return 1 end)
+assert(ok == nil and e == "reader function must return a string")
+f = load("error('here')", "named")
+ok, e = pcall(f)
+assert(e == '[string "named"]:1: here')
+ok, e = pcall(load("error('here')", "=foo"))
+assert(e == "foo:1: here")
+ok, e = pcall(load("error('here')", "@foo.lua"))
+assert(e == "foo.lua:1: here")
+ok, e = pcall(load, "return x", "env", "t", {x = 1})
+assert(not ok and e == "bad argument #4 to 'load' (environments are not supported)")
+
+-- functions survive a round trip, with their constants and nested
+-- functions
+local function fib(n)
+    if n < 2 then return n end
+    local a, b = 0, 1
+    for _ = 2, n do a, b = b, a + b end
+    return b
+end
+f = load(string.dump(fib))
+assert(f(30) == 832040)
+
+local function mixed(...)
+    local t = {1.5, "s", true, false, -7, n = select("#", ...), ...}
+    local function count() return #t end
+    return count(), t.n, t[3], t[4], t[5]
+end
+local c, m, x, y, z = load(string.dump(mixed))(8, 9)
+assert(c == 7 and m == 2 and x == true and y == false and z == -7)
+
+-- upvalues start as nil
+local up = 10
+local function getUp() return up end
+f = load(string.dump(getUp))
+assert(f() == nil)
+
+-- the stripped form is smaller and loses the names in messages
+local function bad() local t = nil; return t.x end
+local full, stripped = string.dump(bad), string.dump(bad, true)
+assert(#stripped < #full)
+ok, e = pcall(load(full))
+assert(string.find(e, "(local 't')", 1, true))
+ok, e = pcall(load(stripped))
+assert(e == "?:0: attempt to index a nil value")
+
+-- modes
+local bin = string.dump(fib)
+assert(load(bin, "b", "b")(10) == 55)
+ok, e = load(bin, "b", "t")
+assert(ok == nil and e == "attempt to load a binary chunk (mode is 't')")
+ok, e = load("return 1", "t", "b")
+assert(ok == nil and e == "attempt to load a text chunk (mode is 'b')")
+
+-- bad chunks are rejected
+ok, e = load(string.sub(bin, 1, -2))
+assert(ok == nil and e == "binary string: truncated precompiled chunk")
+ok, e = load(string.sub(bin, 1, 4) .. "\x52" .. string.sub(bin, 6))
+assert(ok == nil and e == "binary string: version mismatch in precompiled chunk")
+ok, e = load(string.sub(bin, 1, 5) .. "x" .. string.sub(bin, 7))
+assert(ok == nil and e == "binary string: format mismatch in precompiled chunk")
+ok, e = load(bin .. "x")
+assert(ok == nil and e == "binary string: corrupted precompiled chunk")
+ok, e = load("\27Lux")
+assert(ok == nil and e == "binary string: not a precompiled chunk")
+-- loading never crashes, whatever byte is changed
+for i = 1, #bin do
+    local b = string.byte(bin, i)
+    for _, d in ipairs({1, 128}) do
+        load(string.sub(bin, 1, i - 1) .. string.char((b + d) % 256) .. string.sub(bin, i + 1))
+    end
+end
+
+ok, e = pcall(string.dump, print)
+assert(e == "unable to dump given function")
+ok, e = pcall(string.dump, 1)
+assert(string.find(e, "function expected, got number"))
+
+print("ok")
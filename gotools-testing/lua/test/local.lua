@@ -0,0 +1,154 @@
+-- local variables and lexical scoping
+
+x = "global"
+local x = 1
+assert(x == 1)
+
+-- blocks open new scopes
+do
+    local x = 2
+    assert(x == 2)
+    do
+        local x = 3
+        assert(x == 3)
+    end
+    assert(x == 2)
+end
+assert(x == 1)
+
+-- a local statement shadows the previous variable of the same name
+local y = 1
+local get1 = function() return y end
+local y = 2
+assert(get1() == 1)
+assert(y == 2)
+
+-- the initializer sees the outer variable
+local z = 10
+do
+    local z = z + 1
+    assert(z == 11)
+end
+assert(z == 10)
+
+-- multiple locals, missing values are nil, extra ones are dropped
+local a, b, c = 1, 2
+assert(a == 1)
+assert(b == 2)
+assert(c == nil)
+local d, e = 1, 2, 3
+assert(d == 1)
+assert(e == 2)
+local f
+assert(f == nil)
+local function two() return 1, 2 end
+local g, h, i = 0, two()
+assert(g == 0)
+assert(h == 1)
+assert(i == 2)
+
+-- swap evaluates every value before assigning
+local p, q = 1, 2
+p, q = q, p
+assert(p == 2)
+assert(q == 1)
+
+-- assignment goes to the innermost local, else to the global
+local n = 0
+do
+    n = 5
+end
+assert(n == 5)
+do
+    local n = 0
+    n = 7
+end
+assert(n == 5)
+w = nil
+do
+    local w = 1
+end
+assert(w == nil)
+
+-- a function only sees locals declared before it
+local function probe() return later end
+local later = "local"
+later2 = "global"
+assert(probe() == nil)
+
+-- resolution: local, then upvalue, then global
+v = "global v"
+local function outer()
+    local u = "upvalue"
+    return function()
+        local l = "local"
+        return l, u, v
+    end
+end
+local inner = outer()
+local r1, r2, r3 = inner()
+assert(r1 == "local")
+assert(r2 == "upvalue")
+assert(r3 == "global v")
+
+-- closures share the captured variable
+local function pair()
+    local count = 0
+    local function inc() count = count + 1 end
+    local function get() return count end
+    return inc, get
+end
+local inc, get = pair()
+inc()
+inc()
+assert(get() == 2)
+local inc2, get2 = pair()
+inc2()
+assert(get2() == 1)
+assert(get() == 2)
+
+-- each loop iteration has its own locals
+local f1, f2
+for k = 1, 2 do
+    local sq = k * k
+    if k == 1 then f1 = function() return sq end else f2 = function() return sq end end
+end
+assert(f1() == 1)
+assert(f2() == 4)
+
+local j = 0
+local g1, g2
+while j < 2 do
+    j = j + 1
+    local copy = j
+    if j == 1 then g1 = function() return copy end else g2 = function() return copy end end
+end
+assert(g1() == 1)
+assert(g2() == 2)
+
+-- the until condition sees the locals of the body
+local tries = 0
+repeat
+    tries = tries + 1
+    local done = tries >= 3
+until done
+assert(tries == 3)
+
+-- parameters are locals
+local function shadow(x)
+    x = x + 1
+    return x
+end
+assert(shadow(1) == 2)
+assert(x == 1)
+
+-- locals do not leak into globals
+assert(a)
+assert(_VERSION)
+local function leaks()
+    local secret = 1
+end
+leaks()
+assert(secret == nil)
+
+print("ok")
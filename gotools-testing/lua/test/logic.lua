@@ -0,0 +1,77 @@
+-- and, or and not on arbitrary values
+
+local v = nil or "default"
+assert(v == "default")
+v = false or 1
+assert(v == 1)
+v = 0 or 1
+assert(v == 0)
+v = "" or 1
+assert(v == "")
+v = nil and 1
+assert(v == nil)
+v = false and 1
+assert(v == false)
+v = 1 and 2
+assert(v == 2)
+v = "a" and nil
+assert(v == nil)
+
+local t = {}
+v = t or 1
+assert(v == t)
+v = t and "yes"
+assert(v == "yes")
+
+-- not works on every value, only nil and false are false
+assert(not nil)
+assert(not false)
+v = not 0
+assert(v == false)
+v = not ""
+assert(v == false)
+v = not t
+assert(v == false)
+v = not print
+assert(v == false)
+
+-- the right operand is evaluated lazily
+local calls = 0
+local function touch()
+    calls = calls + 1
+    return true
+end
+local r = true or touch()
+assert(calls == 0)
+r = false and touch()
+assert(calls == 0)
+r = nil or touch()
+assert(calls == 1)
+r = 1 and touch()
+assert(calls == 2)
+
+-- guarding index expressions
+local cfg = nil
+local name = cfg and cfg.name
+assert(name == nil)
+cfg = {name = "lua"}
+name = cfg and cfg.name
+assert(name == "lua")
+
+-- default arguments
+local function greet(who)
+    who = who or "world"
+    return "hello " .. who
+end
+assert(greet() == "hello world")
+assert(greet("lua") == "hello lua")
+
+-- conditions use the same truthiness
+local n = 0
+if 0 then n = n + 1 end
+if "" then n = n + 1 end
+if nil then n = n + 1 end
+while nil do n = n + 1 end
+assert(n == 2)
+
+print("ok")
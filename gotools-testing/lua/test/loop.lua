@@ -0,0 +1,70 @@
+-- while / repeat-until / break
+
+i = 0
+n = 0
+while i < 10 do
+    i = i + 1
+    n = n + i
+end
+assert(i == 10)
+assert(n == 55)
+
+-- the body never runs
+r = 0
+while false do r = 1 end
+assert(r == 0)
+
+-- repeat runs at least once
+r = 0
+repeat r = r + 1 until true
+assert(r == 1)
+
+i = 0
+repeat
+    i = i + 1
+until i >= 5
+assert(i == 5)
+
+-- break leaves the innermost loop only
+i = 0
+n = 0
+while true do
+    i = i + 1
+    j = 0
+    while true do
+        j = j + 1
+        if j == 3 then break end
+        n = n + 1
+    end
+    assert(j == 3)
+    if i == 4 then break end
+end
+assert(i == 4)
+assert(n == 8)
+
+i = 0
+repeat
+    i = i + 1
+    if i == 3 then break end
+until false
+assert(i == 3)
+
+-- break inside do ... end still leaves the loop
+i = 0
+while true do
+    do
+        i = i + 1
+        if i == 2 then break end
+    end
+end
+assert(i == 2)
+
+-- statements after break are skipped
+r = 0
+while true do
+    break
+    r = 1
+end
+assert(r == 0)
+
+print("ok")
@@ -0,0 +1,94 @@
+-- the math library
+
+assert(math.abs(-3) == 3)
+assert(math.type(math.abs(-3)) == "integer")
+assert(math.abs(-2.5) == 2.5)
+assert(math.abs(math.mininteger) == math.mininteger)
+
+assert(math.floor(3.7) == 3)
+assert(math.type(math.floor(3.7)) == "integer")
+assert(math.floor(-3.5) == -4)
+assert(math.ceil(3.2) == 4)
+assert(math.type(math.ceil(3.2)) == "integer")
+assert(math.floor(5) == 5)
+assert(math.type(math.floor(1e100)) == "float")
+assert(math.floor("2.5") == 2)
+
+assert(math.sqrt(16) == 4.0)
+assert(math.type(math.sqrt(16)) == "float")
+assert(math.sin(0) == 0.0)
+assert(math.cos(0) == 1.0)
+assert(math.tan(0) == 0.0)
+assert(math.abs(math.asin(1) - math.pi / 2) < 1e-12)
+assert(math.acos(1) == 0.0)
+assert(math.abs(math.atan(1) - math.pi / 4) < 1e-12)
+assert(math.abs(math.atan(1, -1) - 3 * math.pi / 4) < 1e-12)
+assert(math.exp(0) == 1.0)
+assert(math.log(1) == 0.0)
+assert(math.log(8, 2) == 3.0)
+assert(math.log(100, 10) == 2.0)
+assert(math.abs(math.log(27, 3) - 3) < 1e-12)
+
+assert(math.fmod(7, 3) == 1)
+assert(math.type(math.fmod(7, 3)) == "integer")
+assert(math.fmod(-7, 3) == -1)
+assert(math.fmod(7, -3) == 1)
+assert(math.fmod(math.mininteger, -1) == 0)
+assert(math.fmod(5.5, 2) == 1.5)
+assert(not pcall(math.fmod, 1, 0))
+assert(math.fmod(1, 0.0) ~= math.fmod(1, 0.0))
+
+local i, f = math.modf(3.75)
+assert(i == 3.0 and f == 0.75)
+assert(math.type(i) == "float")
+i, f = math.modf(-3.75)
+assert(i == -3.0 and f == -0.75)
+i, f = math.modf(5)
+assert(i == 5 and f == 0.0)
+i, f = math.modf(math.huge)
+assert(i == math.huge and f == 0.0)
+
+assert(math.huge > math.maxinteger)
+assert(-math.huge < math.mininteger)
+assert(math.pi > 3.14 and math.pi < 3.15)
+assert(math.maxinteger == 9223372036854775807)
+assert(math.mininteger == -9223372036854775808 - 0)
+
+assert(math.min(3, 1, 2) == 1)
+assert(math.max(3, 1, 2) == 3)
+assert(math.type(math.max(1, 2.0)) == "float")
+assert(math.type(math.max(2, 1.0)) == "integer")
+assert(math.min(5) == 5)
+assert(not pcall(math.min))
+
+assert(math.ult(1, 2))
+assert(math.ult(1, -1))
+assert(not math.ult(-1, 1))
+
+-- random follows the 5.3 argument conventions
+for _ = 1, 100 do
+    local r = math.random()
+    assert(r >= 0 and r < 1)
+    r = math.random(6)
+    assert(r >= 1 and r <= 6 and math.type(r) == "integer")
+    r = math.random(-3, 3)
+    assert(r >= -3 and r <= 3)
+end
+assert(math.random(5, 5) == 5)
+assert(math.type(math.random(0, math.maxinteger)) == "integer")
+local ok, e = pcall(math.random, math.mininteger, math.maxinteger)
+assert(e == "bad argument #1 to 'random' (interval too large)")
+ok, e = pcall(math.random, 0)
+assert(e == "bad argument #1 to 'random' (interval is empty)")
+ok, e = pcall(math.random, 2, 1)
+assert(e == "bad argument #2 to 'random' (interval is empty)")
+ok, e = pcall(math.random, 1, 2, 3)
+assert(e == "wrong number of arguments")
+
+math.randomseed(42)
+local a, b = math.random(1000), math.random(1000)
+math.randomseed(42)
+assert(math.random(1000) == a)
+assert(math.random(1000) == b)
+
+print("ok")
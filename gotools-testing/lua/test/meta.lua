@@ -0,0 +1,158 @@
+-- metatables and metamethods
+
+local t = {}
+assert(getmetatable(t) == nil)
+local mt = {}
+assert(setmetatable(t, mt) == t)
+assert(getmetatable(t) == mt)
+setmetatable(t, nil)
+assert(getmetatable(t) == nil)
+
+-- protected metatables
+setmetatable(t, {__metatable = "locked"})
+assert(getmetatable(t) == "locked")
+
+-- __index with a table and a function
+local base = {greet = "hello"}
+local obj = setmetatable({}, {__index = base})
+assert(obj.greet == "hello")
+assert(rawget(obj, "greet") == nil)
+obj.greet = "own"
+assert(obj.greet == "own")
+assert(base.greet == "hello")
+
+local calls = 0
+local lazy = setmetatable({}, {__index = function(t, k)
+    calls = calls + 1
+    return k .. "!"
+end})
+assert(lazy.x == "x!")
+assert(lazy.y == "y!")
+assert(calls == 2)
+
+-- __index chains
+local a = {foo = 1}
+local b = setmetatable({}, {__index = a})
+local c = setmetatable({}, {__index = b})
+assert(c.foo == 1)
+
+-- __newindex with a function and a table
+local log = {}
+local watched = setmetatable({}, {__newindex = function(t, k, v)
+    log[#log + 1] = k
+    rawset(t, k, v)
+end})
+watched.a = 1
+watched.a = 2
+assert(#log == 1)
+assert(watched.a == 2)
+
+local store = {}
+local proxy = setmetatable({}, {__newindex = store, __index = store})
+proxy.x = 10
+assert(rawget(proxy, "x") == nil)
+assert(store.x == 10)
+assert(proxy.x == 10)
+
+-- __call
+local callable = setmetatable({}, {__call = function(self, a, b)
+    return a + b, self
+end})
+local sum, self = callable(1, 2)
+assert(sum == 3)
+assert(self == callable)
+
+-- arithmetic metamethods
+local V = {}
+V.__index = V
+local function vec(x, y) return setmetatable({x = x, y = y}, V) end
+V.__add = function(p, q) return vec(p.x + q.x, p.y + q.y) end
+V.__sub = function(p, q) return vec(p.x - q.x, p.y - q.y) end
+V.__mul = function(p, k)
+    if type(p) == "number" then p, k = k, p end
+    return vec(p.x * k, p.y * k)
+end
+V.__div = function(p, k) return vec(p.x / k, p.y / k) end
+V.__mod = function(p, k) return "mod" end
+V.__pow = function(p, k) return "pow" end
+V.__unm = function(p) return vec(-p.x, -p.y) end
+V.__len = function(p) return 2 end
+V.__concat = function(p, q)
+    if type(p) == "table" then p = "vec" end
+    if type(q) == "table" then q = "vec" end
+    return p .. q
+end
+V.__eq = function(p, q) return p.x == q.x and p.y == q.y end
+V.__lt = function(p, q) return p.x < q.x end
+V.__le = function(p, q) return p.x <= q.x end
+V.__tostring = function(p) return "#" .. tostring(p.x) end
+
+local p = vec(1, 2)
+local q = vec(3, 4)
+local r = p + q
+assert(r.x == 4)
+assert(r.y == 6)
+r = q - p
+assert(r.x == 2)
+r = p * 3
+assert(r.y == 6)
+r = 3 * p
+assert(r.y == 6)
+r = q / 2
+assert(r.x == 1.5)
+assert(p % 2 == "mod")
+assert(p ^ 2 == "pow")
+r = -p
+assert(r.x == -1)
+assert(#p == 2)
+assert(p .. "!" == "vec!")
+assert("!" .. p == "!vec")
+assert(p .. q == "vecvec")
+
+-- comparisons
+assert(p == vec(1, 2))
+assert(p ~= q)
+assert(p < q)
+assert(q > p)
+assert(p <= vec(1, 0))
+assert(q >= p)
+-- __eq is only used for two tables
+assert(p ~= 1)
+
+-- __le falls back to not __lt
+local W = {__lt = function(x, y) return x.v < y.v end}
+local w1 = setmetatable({v = 1}, W)
+local w2 = setmetatable({v = 2}, W)
+assert(w1 <= w2)
+local le = w2 <= w1
+assert(not le)
+
+-- __tostring
+assert(tostring(p) == "#1")
+assert(tostring(nil) == "nil")
+
+-- raw access
+local raw = setmetatable({}, {__index = function() return "meta" end, __newindex = function() end})
+raw.x = 1
+assert(raw.x == "meta")
+rawset(raw, "x", 1)
+assert(raw.x == 1)
+assert(rawequal(raw, raw))
+assert(not rawequal(p, vec(1, 2)))
+assert(rawlen({1, 2}) == 2)
+assert(rawlen("abc") == 3)
+
+-- object oriented style
+local Account = {}
+Account.__index = Account
+function Account.new(balance)
+    return setmetatable({balance = balance}, Account)
+end
+function Account.deposit(self, v)
+    self.balance = self.balance + v
+end
+local acc = Account.new(100)
+acc.deposit(acc, 50)
+assert(acc.balance == 150)
+
+print("ok")
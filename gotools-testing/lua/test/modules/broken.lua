@@ -0,0 +1,3 @@
+-- a module with a syntax error
+
+local x = = 1
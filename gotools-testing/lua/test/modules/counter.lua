@@ -0,0 +1,10 @@
+-- a module that counts how often it is loaded
+
+loads = (loads or 0) + 1
+
+local M = {}
+M.name, M.file = ...
+
+function M.add(a, b) return a + b end
+
+return M
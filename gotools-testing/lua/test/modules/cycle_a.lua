@@ -0,0 +1,3 @@
+-- requires cycle_b, which requires this module again
+
+return {b = require("modules.cycle_b")}
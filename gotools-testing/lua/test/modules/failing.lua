@@ -0,0 +1,3 @@
+-- a module that raises an error while loading
+
+error("cannot load")
@@ -0,0 +1,3 @@
+-- a module that returns nothing
+
+noreturn_ran = true
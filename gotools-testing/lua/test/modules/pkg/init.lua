@@ -0,0 +1,3 @@
+-- a package loaded through its init.lua
+
+return {sub = require("modules.pkg.sub")}
@@ -0,0 +1,114 @@
+-- integers, floats and the bitwise operators
+
+assert(math.type(1) == "integer")
+assert(math.type(1.0) == "float")
+assert(math.type(1e2) == "float")
+assert(math.type(0x10) == "integer")
+assert(math.type("1") == nil)
+assert(1 == 1.0)
+assert(tostring(1) == "1")
+assert(tostring(1.0) == "1.0")
+assert(tostring(-0.0) == "-0.0")
+assert(tostring(1e15) == "1e+15")
+assert(tostring(2^53) == "9.007199254741e+15")
+assert(tostring(0.1) == "0.1")
+
+-- results keep the integer subtype unless a float is involved
+assert(math.type(2 + 3) == "integer")
+assert(math.type(2 + 3.0) == "float")
+assert(math.type(2 * 3) == "integer")
+assert(math.type(6 / 3) == "float")
+assert(math.type(2 ^ 2) == "float")
+assert(math.type(-3) == "integer")
+assert(math.type(#"abc") == "integer")
+
+-- integer arithmetic wraps around
+assert(math.maxinteger + 1 == math.mininteger)
+assert(math.mininteger - 1 == math.maxinteger)
+assert(math.maxinteger * 2 == -2)
+assert(-math.mininteger == math.mininteger)
+assert(0xffffffffffffffff == -1)
+assert(math.type(9223372036854775807) == "integer")
+assert(math.type(9223372036854775808) == "float")
+
+-- floor division and modulo round towards minus infinity
+assert(7 // 2 == 3)
+assert(-7 // 2 == -4)
+assert(7 // -2 == -4)
+assert(7.0 // 2 == 3.0)
+assert(math.type(7.0 // 2) == "float")
+assert(7 % 3 == 1)
+assert(-7 % 3 == 2)
+assert(7 % -3 == -2)
+assert(-7 % -3 == -1)
+assert(5.5 % 2 == 1.5)
+assert(-5.5 % 2 == 0.5)
+assert(math.mininteger // -1 == math.mininteger)
+assert(1 // 0.0 == 1 / 0)
+
+-- bitwise operators
+assert(5 & 3 == 1)
+assert(5 | 3 == 7)
+assert(5 ~ 3 == 6)
+assert(~0 == -1)
+assert(~5 == -6)
+assert(1 << 4 == 16)
+assert(256 >> 4 == 16)
+assert(1 << 63 == math.mininteger)
+assert(1 << 64 == 0)
+assert(-1 >> 1 == math.maxinteger)
+assert(2 >> -1 == 4)
+assert(1 << -1 == 0)
+assert(3.0 | 0 == 3)
+assert(math.type(3.0 | 0) == "integer")
+
+-- comparisons between integers and floats are exact
+assert(1 < 1.5)
+assert(2 > 1.5)
+assert(1 <= 1.0)
+assert(math.maxinteger < 2^63)
+assert(math.maxinteger + 0.0 == 2^63)
+assert(math.maxinteger ~= 2^63)
+assert(math.mininteger == -2^63)
+
+-- tointeger
+assert(math.tointeger(3.0) == 3)
+assert(math.type(math.tointeger(3.0)) == "integer")
+assert(math.tointeger(3.5) == nil)
+assert(math.tointeger("x") == nil)
+
+-- float keys with an integer value are integer keys
+local t = {}
+t[1.0] = "a"
+t[2] = "b"
+assert(t[1] == "a")
+assert(t[2.0] == "b")
+assert(#t == 2)
+for k in pairs(t) do
+    assert(math.type(k) == "integer")
+end
+
+-- numeric for keeps the type of its values
+for i = 1, 2 do
+    assert(math.type(i) == "integer")
+end
+for i = 1.0, 2 do
+    assert(math.type(i) == "float")
+end
+local n = 0
+for i = 1, 2, 0.5 do
+    n = n + 1
+end
+assert(n == 3)
+n = 0
+for i = math.maxinteger - 2, math.maxinteger do
+    n = n + 1
+end
+assert(n == 3)
+n = 0
+for i = 3, 1.5, -1 do
+    n = n + 1
+end
+assert(n == 2)
+
+print("ok")
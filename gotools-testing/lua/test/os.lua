@@ -0,0 +1,78 @@
+-- the os library
+
+local now = os.time()
+assert(math.type(now) == "integer")
+assert(math.type(os.clock()) == "float")
+assert(os.clock() >= 0)
+-- the clock counts the processor time of the script
+local c = os.clock()
+local x = 0
+for i = 1, 2000000 do x = x + i end
+assert(os.clock() > c)
+
+-- the table form of os.time and os.date agree
+local t = os.date("*t", now)
+assert(os.time(t) == now)
+assert(t.month >= 1 and t.month <= 12)
+assert(t.wday >= 1 and t.wday <= 7)
+assert(type(t.isdst) == "boolean")
+local noon = os.time({year = 2020, month = 2, day = 29})
+t = os.date("*t", noon)
+assert(t.year == 2020 and t.month == 2 and t.day == 29)
+assert(t.hour == 12 and t.min == 0 and t.sec == 0)
+assert(t.yday == 60 and t.wday == 7)
+
+-- fields are normalized
+t = {year = 2021, month = 1, day = 32, hour = 0}
+os.time(t)
+assert(t.month == 2 and t.day == 1)
+assert(os.time({year = 2020, month = 3, day = 1, hour = 0}) -
+    os.time({year = 2020, month = 2, day = 28, hour = 0}) == 2 * 86400)
+local ok, e = pcall(os.time, {year = 2020, month = 1})
+assert(e == "field 'day' missing in date table")
+ok, e = pcall(os.time, {year = 2020, month = 1, day = 1.5})
+assert(e == "field 'day' is not an integer")
+
+-- formats, in UTC to be independent of the time zone
+local d = 1234567890
+assert(os.date("!%Y-%m-%d %H:%M:%S", d) == "2009-02-13 23:31:30")
+assert(os.date("!%c", d) == "Fri Feb 13 23:31:30 2009")
+assert(os.date("!%x %X %p", d) == "02/13/09 23:31:30 PM")
+assert(os.date("!%a %A %b %B %j", d) == "Fri Friday Feb February 044")
+assert(os.date("!%I %y %e %D %F %T %%", d) == "11 09 13 02/13/09 2009-02-13 23:31:30 %")
+assert(os.date("!%u %w %U %W %V %G", d) == "5 5 06 06 07 2009")
+assert(os.date("!%Ey %Od", d) == "09 13")
+assert(os.date("!%H", 0) == "00")
+t = os.date("!*t", d)
+assert(t.year == 2009 and t.hour == 23 and t.yday == 44 and t.wday == 6)
+ok, e = pcall(os.date, "%Q")
+assert(e == "bad argument #1 to 'date' (invalid conversion specifier '%Q')")
+assert(type(os.date()) == "string")
+
+assert(os.difftime(now + 10, now) == 10.0)
+assert(math.type(os.difftime(now, now)) == "float")
+assert(os.difftime(2.5, 1) == 1.5)
+assert(os.difftime(3) == 3.0)
+
+assert(type(os.getenv("PATH")) == "string")
+assert(os.getenv("NO_SUCH_VARIABLE_FOR_LUA") == nil)
+
+-- files
+local name = os.tmpname()
+assert(type(name) == "string")
+local f = assert(io.open(name, "w"))
+f:write("data")
+f:close()
+local other = name .. ".moved"
+assert(os.rename(name, other) == true)
+assert(io.open(name) == nil)
+f = assert(io.open(other))
+assert(f:read("a") == "data")
+f:close()
+assert(os.remove(other) == true)
+local r, msg = os.remove(other)
+assert(r == nil and msg == other .. ": No such file or directory")
+r, msg = os.rename(other, name)
+assert(r == nil and string.find(msg, "No such file or directory"))
+
+print("ok")
@@ -0,0 +1,117 @@
+-- Lua patterns: find, match, gmatch and gsub
+
+local function same(t, ...)
+    local n = select("#", ...)
+    if #t ~= n then return false end
+    for i = 1, n do
+        if t[i] ~= select(i, ...) then return false end
+    end
+    return true
+end
+
+-- find returns positions and captures
+assert(same({1, 5}, string.find("hello world", "hello")))
+assert(same({7, 9}, string.find("hello world", "wor")))
+assert(same({3, 4}, string.find("hello", "l+")))
+assert(same({2, 2}, string.find("a.b", ".", 1, true)))
+assert(same({1, 1}, string.find("a.b", ".")))
+assert(string.find("abc", "x") == nil)
+assert(same({3, 4, "l", "l"}, string.find("hello", "(l)(l)")))
+assert(string.find("abc", "b", -1) == nil)
+assert(same({2, 2}, string.find("abc", "b", -2)))
+assert(same({4, 3}, string.find("abc", "", 4)))
+assert(string.find("abc", "", 5) == nil)
+
+-- character classes and sets
+assert(string.match("abc123", "%a+") == "abc")
+assert(string.match("abc123", "%d+") == "123")
+assert(string.match("a \t\nb", "%s+") == " \t\n")
+assert(string.match("Hello", "%u%l+") == "Hello")
+assert(string.match("x_1!", "%w+") == "x")
+assert(string.match("a,b", "%p") == ",")
+assert(string.match("0xFF", "%x+$") == "FF")
+assert(string.match("abc", "%A") == nil)
+assert(string.match("ab12", "[%d]+") == "12")
+assert(string.match("hello", "[a-f]") == "e")
+assert(string.match("x9", "[^%a]") == "9")
+assert(string.match("[x]", "[]]") == "]")
+assert(string.match("a-b", "[%-]") == "-")
+assert(string.match("a-b", "[a-]+") == "a-")
+
+-- repetitions
+assert(string.match("hello", ".-l") == "hel")
+assert(string.match("hello", ".*l") == "hell")
+assert(string.match("color colour", "colou?r") == "color")
+assert(string.match("aaa", "a-$") == "aaa")
+assert(string.match("<a><b>", "<(.-)>") == "a")
+assert(string.match("<a><b>", "<(.*)>") == "a><b")
+
+-- anchors
+assert(string.match("  trim  ", "^%s*(.-)%s*$") == "trim")
+assert(string.match("abc", "^b") == nil)
+assert(string.match("a$b", "$b") == "$b")
+
+-- captures
+local k, v = string.match("key = value", "(%w+)%s*=%s*(%w+)")
+assert(k == "key" and v == "value")
+assert(same({"2024", "01", "15"}, string.match("2024-01-15", "(%d+)-(%d+)-(%d+)")))
+assert(same({3, 5}, string.match("hello", "()ll()")))
+assert(string.match("x", "()") == 1)
+assert(same({"a", "b"}, string.match("abcabc", "(a)(b)c%1%2")))
+assert(string.match("say 'hi' or \"yo\"", "([\"'])(.-)%1") == "'")
+
+-- balanced matches and frontiers
+assert(string.match("f(a(b)c)d", "%b()") == "(a(b)c)")
+assert(string.match("THE (quick) fox", "%f[%a]%a+") == "THE")
+assert(select(2, string.gsub("THE (quick) fox", "%f[%a]%a+", "W")) == 3)
+assert(string.gsub("hello world", "%f[%w]%w+", string.upper) == "HELLO WORLD")
+
+-- gmatch
+local words = {}
+for w in string.gmatch("one two  three", "%a+") do
+    words[#words + 1] = w
+end
+assert(same(words, "one", "two", "three"))
+local pairs_ = {}
+for key, val in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do
+    pairs_[key] = val
+end
+assert(pairs_.a == "1" and pairs_.b == "2")
+local n = 0
+for _ in ("abc"):gmatch("") do n = n + 1 end
+assert(n == 4)
+
+-- gsub with strings, tables and functions
+assert(same({"hell0 w0rld", 2}, string.gsub("hello world", "o", "0")))
+assert(same({"-h-e-l-l-o-", 6}, string.gsub("hello", "", "-")))
+assert(string.gsub("abc", "%w", "%0%0") == "aabbcc")
+assert(string.gsub("hello world", "(%w+)", "<%1>") == "<hello> <world>")
+assert(string.gsub("a b", "(%w) (%w)", "%2 %1") == "b a")
+assert(string.gsub("50%", "%%", "%%%%") == "50%%")
+assert(string.gsub("$name is $age", "%$(%w+)", {name = "Bob", age = 42}) == "Bob is 42")
+assert(string.gsub("$x $y", "%$(%w+)", {x = "1"}) == "1 $y")
+assert(string.gsub("1 2 3", "%d", function(d) return d * 2 end) == "2 4 6")
+assert(string.gsub("abc", "%w", function(c) if c == "b" then return nil end return "." end) == ".b.")
+assert(same({"heLlo", 1}, string.gsub("hello", "l", "L", 1)))
+assert(same({"Xbc", 1}, string.gsub("abc", "^a", "X")))
+assert(same({"abc", 0}, string.gsub("abc", "^b", "X")))
+assert(("hello"):gsub("l", "") == "heo")
+
+-- errors
+local function err(f, ...)
+    local ok, e = pcall(f, ...)
+    assert(not ok)
+    return e
+end
+assert(err(string.match, "x", "%") == "malformed pattern (ends with '%')")
+assert(err(string.match, "x", "[a") == "malformed pattern (missing ']')")
+assert(err(string.match, "x", "x)") == "invalid pattern capture")
+assert(err(string.find, "abc", "a)") == "invalid pattern capture")
+assert(err(string.match, "x", "%1") == "invalid capture index %1")
+assert(err(string.match, "x", "%f") == "missing '[' after '%f' in pattern")
+assert(err(string.gsub, "x", "x", "%2") == "invalid capture index %2")
+assert(err(string.gsub, "x", "x", "%z") == "invalid use of '%' in replacement string")
+assert(err(string.gsub, "x", "x", function() return {} end) == "invalid replacement value (a table)")
+assert(err(string.match, string.rep("a", 1000), string.rep("a?", 1000)) == "pattern too complex")
+
+print("ok")
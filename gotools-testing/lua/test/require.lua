@@ -0,0 +1,76 @@
+-- require and the package library
+
+assert(type(package.path) == "string")
+assert(package.loaded.string == string)
+assert(package.loaded.package == package)
+assert(require("math") == math)
+
+-- modules next to the script are found, loaded once and cached
+local counter = require("modules.counter")
+assert(counter.add(1, 2) == 3)
+assert(counter.name == "modules.counter")
+assert(string.find(counter.file, "modules/counter.lua$"))
+assert(require("modules.counter") == counter)
+assert(loads == 1)
+assert(package.loaded["modules.counter"] == counter)
+
+-- a module that returns nothing is stored as true
+assert(require("modules.noreturn") == true)
+assert(noreturn_ran)
+
+-- packages use init.lua
+assert(require("modules.pkg").sub == "sub")
+assert(package.loaded["modules.pkg.sub"] == "sub")
+
+-- clearing package.loaded loads the module again
+package.loaded["modules.counter"] = nil
+assert(require("modules.counter") ~= counter)
+assert(loads == 2)
+
+-- preload comes first
+package.preload["virtual"] = function(name, extra)
+    return {name = name, extra = extra}
+end
+local v = require("virtual")
+assert(v.name == "virtual" and v.extra == ":preload:")
+assert(require("virtual") == v)
+
+-- custom searchers
+table.insert(package.searchers, function(name)
+    if name == "generated" then
+        return function() return 42 end
+    end
+    return "\n\tno generated module '" .. name .. "'"
+end)
+assert(require("generated") == 42)
+
+-- errors
+local ok, e = pcall(require, "modules.missing")
+assert(not ok)
+assert(string.find(e, "module 'modules.missing' not found:", 1, true))
+assert(string.find(e, "no field package.preload['modules.missing']", 1, true))
+assert(string.find(e, "no file '", 1, true))
+assert(string.find(e, "no generated module 'modules.missing'", 1, true))
+
+ok, e = pcall(require, "modules.cycle_a")
+assert(not ok)
+assert(string.find(e, "circular require of module 'modules.cycle_a' " ..
+    "(modules.cycle_a -> modules.cycle_b -> modules.cycle_a)", 1, true))
+assert(package.loaded["modules.cycle_a"] == nil)
+
+ok, e = pcall(require, "modules.broken")
+assert(not ok)
+assert(string.find(e, "error loading module 'modules.broken' from file", 1, true))
+
+ok, e = pcall(require, "modules.failing")
+assert(not ok and string.find(e, "cannot load"))
+assert(package.loaded["modules.failing"] == nil)
+
+-- searchpath
+local path = "./?.lua;./?/init.lua"
+local file, tried = package.searchpath("no.such", path)
+assert(file == nil)
+assert(tried == "\n\tno file './no/such.lua'\n\tno file './no/such/init.lua'")
+assert(package.searchpath("test.require", path) == "./test/require.lua")
+
+print("ok")
@@ -0,0 +1,108 @@
+-- the string library, string.format and method calls
+
+local s = "Hello"
+assert(s:len() == 5)
+assert(s:upper() == "HELLO")
+assert(s:lower() == "hello")
+assert(s:reverse() == "olleH")
+assert(s:rep(3) == "HelloHelloHello")
+assert(s:rep(2, ", ") == "Hello, Hello")
+assert(s:rep(0) == "")
+assert(("x"):rep(-1) == "")
+
+-- sub and byte take negative positions from the end
+assert(s:sub(2, 3) == "el")
+assert(s:sub(-3) == "llo")
+assert(s:sub(2, -2) == "ell")
+assert(s:sub(0) == "Hello")
+assert(s:sub(4, 2) == "")
+assert(s:sub(10) == "")
+assert(s:byte() == 72)
+assert(s:byte(-1) == 111)
+local a, b, c = s:byte(1, 3)
+assert(a == 72 and b == 101 and c == 108)
+assert(select("#", s:byte(10)) == 0)
+assert(string.char(72, 105) == "Hi")
+assert(string.char() == "")
+
+-- numbers are accepted where strings are expected
+assert(string.len(123) == 3)
+assert(string.rep(1, 3) == "111")
+
+-- escape sequences
+assert("\65\066\x43" == "ABC")
+assert("\u{48}\u{49}" == "HI")
+assert(#"\0" == 1)
+assert("a\z
+        b" == "ab")
+assert('\'' == "'")
+
+-- format
+assert(string.format("%d", 42) == "42")
+assert(string.format("%5d|%-5d|%05d", 42, 42, 42) == "   42|42   |00042")
+assert(string.format("%i", 3.0) == "3")
+assert(string.format("%x %X %o", 255, 255, 8) == "ff FF 10")
+assert(string.format("%x", -1) == "ffffffffffffffff")
+assert(string.format("%c%c", 76, 117) == "Lu")
+assert(string.format("%s|%10s|%-4s|%.2s", "hi", "hi", "hi", "hello") == "hi|        hi|hi  |he")
+assert(string.format("%s %s %s", 1, 1.5, nil) == "1 1.5 nil")
+assert(string.format("%5.2f", 3.14159) == " 3.14")
+assert(string.format("%.3f", 2) == "2.000")
+assert(string.format("%g %g %g", 0.1, 1e20, 100000) == "0.1 1e+20 100000")
+assert(string.format("%e", 12345.678) == "1.234568e+04")
+assert(string.format("%G", 1e-10) == "1E-10")
+assert(string.format("%a", 1.0) == "0x1p+0")
+assert(string.format("%5.1f|", 1 / 0) == "  inf|")
+assert(string.format("%%") == "%")
+assert(string.format("%q", 'a "b"\\') == '"a \\"b\\"\\\\"')
+assert(string.format("%q", "\n") == '"\\\n"')
+assert(string.format("%q", "\1x\0011") == '"\\1x\\0011"')
+assert(string.format("%q", 1) == "1")
+assert(string.format("%q", 1.5) == "0x1.8p+0")
+assert(string.format("%q", 1 / 0) == "1e9999")
+local t = setmetatable({}, {__tostring = function() return "T" end})
+assert(string.format("%s", t) == "T")
+assert(("%d items"):format(5) == "5 items")
+
+local ok, e = pcall(string.format, "%d", 1.5)
+assert(e == "bad argument #2 to 'format' (number has no integer representation)")
+ok, e = pcall(string.format, "%y", 1)
+assert(e == "invalid conversion '%y' to 'format'")
+ok, e = pcall(string.format, "%d")
+assert(e == "bad argument #2 to 'format' (no value)")
+
+-- the string metatable
+assert(getmetatable("").__index == string)
+ok, e = pcall(function() return ("x"):nope() end)
+assert(not ok)
+
+-- method calls and method definitions
+local counter = {n = 0}
+function counter:inc(k)
+    self.n = self.n + (k or 1)
+    return self
+end
+counter:inc():inc(5)
+assert(counter.n == 6)
+
+local a = {b = {}}
+function a.b:me() return self end
+assert(a.b:me() == a.b)
+
+-- the object is evaluated once
+local calls = 0
+local function get()
+    calls = calls + 1
+    return counter
+end
+get():inc()
+assert(calls == 1)
+assert(counter.n == 7)
+
+-- method calls with table and string arguments
+function counter:add(t) return self.n + t[1] end
+assert(counter:add{3} == 10)
+function counter:echo(s) return s end
+assert(counter:echo"x" == "x")
+
+print("ok")
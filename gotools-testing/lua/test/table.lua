@@ -0,0 +1,212 @@
+-- tables: constructors, indexing, keys and length
+
+local t = {}
+assert(type(t) == "table")
+assert(#t == 0)
+assert(t.x == nil)
+assert(t[1] == nil)
+
+-- constructors
+t = {10, 20, 30}
+assert(#t == 3)
+assert(t[1] == 10)
+assert(t[3] == 30)
+assert(t[4] == nil)
+
+t = {x = 1, y = 2}
+assert(t.x == 1)
+assert(t["y"] == 2)
+assert(#t == 0)
+
+local k = "key"
+t = {1, 2, x = 3, [k] = 4, [10] = 5; 6}
+assert(t[1] == 1)
+assert(t[2] == 2)
+assert(t[3] == 6)
+assert(t.x == 3)
+assert(t.key == 4)
+assert(t[10] == 5)
+assert(#t == 3)
+
+-- trailing separators
+t = {1, 2,}
+assert(#t == 2)
+t = {a = 1;}
+assert(t.a == 1)
+
+-- positional items win over explicit ones
+t = {[1] = "explicit", "positional"}
+assert(t[1] == "positional")
+
+-- the last positional item expands
+local function three() return 1, 2, 3 end
+t = {three()}
+assert(#t == 3)
+t = {three(), three()}
+assert(#t == 4)
+t = {(three())}
+assert(#t == 1)
+local function pack(...) return {...} end
+t = pack(1, 2, 3, 4)
+assert(#t == 4)
+assert(t[4] == 4)
+
+-- nested tables and chained access
+t = {a = {b = {c = "deep"}}, list = {{1}, {2, 3}}}
+assert(t.a.b.c == "deep")
+assert(t["a"]["b"].c == "deep")
+assert(t.list[2][2] == 3)
+t.a.b.c = "changed"
+assert(t.a.b.c == "changed")
+t.a.new = {}
+t.a.new.x = 1
+assert(t.a.new.x == 1)
+
+-- writes
+t = {}
+t.x = 1
+t["y"] = 2
+t[1] = "one"
+assert(t.x == 1)
+assert(t.y == 2)
+assert(t[1] == "one")
+t.x = nil
+assert(t.x == nil)
+
+-- keys of any non-nil type
+t = {}
+local key1, key2 = {}, {}
+t[key1] = "first"
+t[key2] = "second"
+t[true] = "yes"
+t[false] = "no"
+t[1.5] = "float"
+t[-1] = "negative"
+t[0] = "zero"
+local f = function() end
+t[f] = "function"
+assert(t[key1] == "first")
+assert(t[key2] == "second")
+assert(t[{}] == nil)
+assert(t[true] == "yes")
+assert(t[false] == "no")
+assert(t[1.5] == "float")
+assert(t[-1] == "negative")
+assert(t[0] == "zero")
+assert(t[f] == "function")
+assert(#t == 0)
+
+-- numbers and strings are different keys
+t = {}
+t[1] = "number"
+t["1"] = "string"
+assert(t[1] == "number")
+assert(t["1"] == "string")
+
+-- the array part grows, also when filled out of order
+t = {}
+for i = 1, 100 do t[i] = i * i end
+assert(#t == 100)
+assert(t[50] == 2500)
+t = {}
+t[3] = 3
+t[2] = 2
+assert(#t == 0)
+t[1] = 1
+assert(#t == 3)
+
+-- # returns a border
+t = {1, 2, 3}
+t[#t + 1] = 4
+assert(#t == 4)
+t[#t] = nil
+assert(#t == 3)
+t = {1, 2, 3, nil, 5}
+local n = #t
+assert(n == 5 or n == 3)
+t = {nil, nil, 3}
+n = #t
+assert(n == 3 or n == 0)
+t = {n = 1}
+assert(#t == 0)
+
+-- tables are compared and shared by identity
+local a = {}
+local b = a
+b.x = 1
+assert(a.x == 1)
+assert(a == b)
+assert(a ~= {})
+
+-- functions stored in tables
+local lib = {}
+function lib.add(x, y) return x + y end
+lib.sub = function(x, y) return x - y end
+local mod = {inner = {}}
+function mod.inner.mul(x, y) return x * y end
+assert(lib.add(1, 2) == 3)
+assert(lib.sub(5, 2) == 3)
+assert(mod.inner.mul(2, 3) == 6)
+
+-- call sugar
+local function count(t) return #t end
+assert(count{1, 2, 3} == 3)
+local function id(s) return s end
+assert(id"str" == "str")
+
+-- traversal
+t = {10, 20, 30, x = 1, y = 2}
+local sum, keys = 0, 0
+for k, v in pairs(t) do
+    sum = sum + v
+    keys = keys + 1
+end
+assert(sum == 63)
+assert(keys == 5)
+
+local seen = {}
+for i, v in ipairs({1, 2, nil, 4}) do
+    seen[#seen + 1] = i
+end
+assert(#seen == 2)
+
+k, v = next({})
+assert(k == nil)
+k, v = next({"only"})
+assert(k == 1)
+assert(v == "only")
+assert(next({}, nil) == nil)
+
+-- fields can be cleared while traversing
+t = {a = 1, b = 2, c = 3, 1, 2, 3}
+for k in pairs(t) do t[k] = nil end
+assert(next(t) == nil)
+
+-- tables and keys of all the targets are evaluated before any assignment
+local i = 1
+t = {}
+i, t[i] = i + 1, 20
+assert(i == 2)
+assert(t[1] == 20)
+assert(t[2] == nil)
+
+local a = {}
+local old = a
+a, a.x = {}, 1
+assert(old.x == 1)
+assert(a.x == nil)
+
+g = 1
+t = {}
+g, t[g] = 5, "x"
+assert(g == 5)
+assert(t[1] == "x")
+assert(t[5] == nil)
+
+local u = {}
+t = {}
+t, t[1], u[t] = {}, "a", "b"
+assert(u[t] == nil)
+assert(#t == 0)
+
+print("ok")
@@ -0,0 +1,111 @@
+-- the table library
+
+local function same(a, b)
+    if #a ~= #b then return false end
+    for i = 1, #a do
+        if a[i] ~= b[i] then return false end
+    end
+    return true
+end
+
+-- insert and remove
+local t = {}
+table.insert(t, "a")
+table.insert(t, "c")
+table.insert(t, 2, "b")
+table.insert(t, 1, "z")
+assert(same(t, {"z", "a", "b", "c"}))
+table.insert(t, #t + 1, "d")
+assert(same(t, {"z", "a", "b", "c", "d"}))
+assert(not pcall(table.insert, t, 0, "x"))
+assert(not pcall(table.insert, t, 7, "x"))
+local ok, e = pcall(table.insert, t, 1, 2, 3)
+assert(e == "wrong number of arguments to 'insert'")
+ok, e = pcall(table.insert, nil, 1)
+assert(e == "bad argument #1 to 'insert' (table expected, got nil)")
+
+assert(table.remove(t) == "d")
+assert(table.remove(t, 1) == "z")
+assert(same(t, {"a", "b", "c"}))
+assert(table.remove(t, 2) == "b")
+assert(same(t, {"a", "c"}))
+assert(table.remove(t, #t + 1) == nil)
+assert(#t == 2)
+assert(not pcall(table.remove, t, 5))
+assert(table.remove({}) == nil)
+assert(table.remove({}, 0) == nil)
+t = {}
+for i = 1, 10 do table.insert(t, i) end
+for i = 10, 1, -1 do assert(table.remove(t) == i and #t == i - 1) end
+
+-- concat
+assert(table.concat({}) == "")
+assert(table.concat({1, 2, 3}) == "123")
+assert(table.concat({"a", "b", "c"}, ", ") == "a, b, c")
+assert(table.concat({"a", "b", "c", "d"}, "-", 2, 3) == "b-c")
+assert(table.concat({"a", "b"}, "-", 3) == "")
+assert(table.concat({1, 2.5, "x"}, " ") == "1 2.5 x")
+ok, e = pcall(table.concat, {1, {}, 3})
+assert(e == "invalid value (at index 2) in table for 'concat'")
+
+-- pack and unpack
+t = table.pack(1, nil, 3)
+assert(t.n == 3 and t[1] == 1 and t[2] == nil and t[3] == 3)
+assert(table.pack().n == 0)
+local a, b, c = table.unpack({1, 2, 3})
+assert(a == 1 and b == 2 and c == 3)
+assert(select("#", table.unpack({})) == 0)
+assert(select("#", table.unpack({1, 2, 3}, 2)) == 2)
+a, b = table.unpack({1, 2, 3}, 2, 3)
+assert(a == 2 and b == 3)
+assert(select("#", table.unpack({}, 1, 3)) == 3)
+assert(select("#", table.unpack({}, 3, 1)) == 0)
+assert(not pcall(table.unpack, {}, 1, 1e8))
+assert(not pcall(table.unpack, {}, math.mininteger, math.maxinteger))
+
+-- move
+t = table.move({1, 2, 3}, 1, 3, 2)
+assert(same(t, {1, 1, 2, 3}))
+t = table.move({1, 2, 3, 4}, 2, 4, 1)
+assert(t[1] == 2 and t[2] == 3 and t[3] == 4 and t[4] == 4)
+local dst = table.move({1, 2, 3}, 1, 3, 1, {})
+assert(same(dst, {1, 2, 3}))
+assert(same(table.move({1, 2}, 1, 0, 5), {1, 2}))
+assert(not pcall(table.move, {}, 1, math.maxinteger, 2))
+
+-- sort
+t = {5, 2, 8, 1, 9, 3}
+table.sort(t)
+assert(same(t, {1, 2, 3, 5, 8, 9}))
+table.sort(t, function(x, y) return x > y end)
+assert(same(t, {9, 8, 5, 3, 2, 1}))
+t = {"pear", "apple", "fig"}
+table.sort(t)
+assert(same(t, {"apple", "fig", "pear"}))
+t = {}
+for i = 1, 200 do t[i] = (i * 37) % 101 end
+table.sort(t)
+for i = 2, #t do assert(t[i - 1] <= t[i]) end
+t = {}
+table.sort(t)
+t = {3, 1.5, 2}
+table.sort(t)
+assert(same(t, {1.5, 2, 3}))
+assert(not pcall(table.sort, {1, "x", 2}))
+t = {}
+for i = 1, 100 do t[i] = i % 7 end
+ok, e = pcall(table.sort, t, function(x, y) return true end)
+assert(not ok and string.find(e, "invalid order function for sorting"))
+
+-- the library sees the same border as #
+t = {1, 2, 3}
+t[#t] = nil
+table.insert(t, "x")
+assert(#t == 3 and t[3] == "x")
+local proxy = setmetatable({}, {__len = function() return 2 end,
+    __index = function(_, i) return i * 10 end})
+assert(table.concat(proxy, ",") == "10,20")
+a, b = table.unpack(proxy)
+assert(a == 10 and b == 20)
+
+print("ok")